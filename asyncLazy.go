@@ -0,0 +1,96 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// 异步延迟初始化的值：第一次Get时在后台执行factory，所有等待者共享同一个结果
+type AsyncLazy[T any] struct {
+	factory        func(ctx context.Context) (T, error)
+	retryOnFailure bool
+
+	mu  sync.Mutex
+	tcs *TaskCompletionSourceT[T]
+}
+
+func NewAsyncLazy[T any](factory func(ctx context.Context) (T, error)) *AsyncLazy[T] {
+	return &AsyncLazy[T]{
+		factory: factory,
+	}
+}
+
+// factory失败时不缓存错误，下一次Get会重新执行factory。已经在等待的调用者仍然会收到这次的错误
+func (me *AsyncLazy[T]) RetryOnFailure() *AsyncLazy[T] {
+	me.retryOnFailure = true
+	return me
+}
+
+// 返回执行factory的任务，如果还没开始则马上开始
+func (me *AsyncLazy[T]) Task() *TaskT[T] {
+	me.mu.Lock()
+	if me.tcs != nil && !(me.retryOnFailure && me.failed()) {
+		defer me.mu.Unlock()
+		return &me.tcs.TaskT
+	}
+	tcs := NewTaskCompletionSourceT[T]()
+	me.tcs = tcs
+	me.mu.Unlock()
+
+	// 在锁外提交，调度器阻塞或者在当前go proc执行factory时不影响其他调用者
+	err := tcs.submitTo(DefaultTaskScheduler(), func() {
+		tcs.run(func() (T, error) {
+			return me.factory(context.Background())
+		})
+	})
+	if err != nil { // factory没有执行，不缓存调度器的错误
+		me.mu.Lock()
+		if me.tcs == tcs {
+			me.tcs = nil
+		}
+		me.mu.Unlock()
+	}
+	return &tcs.TaskT
+}
+
+// 调用前须持有me.mu
+func (me *AsyncLazy[T]) failed() bool {
+	select {
+	case <-me.tcs.Done():
+		return me.tcs.err != nil
+	default:
+		return false
+	}
+}
+
+// 等待并返回值，如果还没开始则马上开始。
+// ctx结束或收到程序退出信号时马上返回ctx.Err()或ProgramExitingError，不影响后台执行的factory
+func (me *AsyncLazy[T]) Get(ctx context.Context) (T, error) {
+	task := me.Task()
+	select {
+	case <-task.Done():
+		return task.GetResult()
+	case <-ctx.Done():
+		var r T
+		return r, ctx.Err()
+	case <-ProgramDone():
+		var r T
+		return r, ProgramExitingError
+	}
+}
+
+// factory是否已经成功执行完毕
+func (me *AsyncLazy[T]) IsValueCreated() bool {
+	me.mu.Lock()
+	tcs := me.tcs
+	me.mu.Unlock()
+	if tcs == nil {
+		return false
+	}
+	select {
+	case <-tcs.Done():
+		return tcs.err == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncLazy(t *testing.T) {
+	var count atomic.Int32
+	lazy := NewAsyncLazy(func(ctx context.Context) (int, error) {
+		count.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return 123, nil
+	})
+	if count.Load() != 0 || lazy.IsValueCreated() {
+		t.Errorf(`factory should not start before Get`)
+	}
+
+	tasks := make([]*TaskT[int], 10)
+	for i := range tasks {
+		tasks[i] = NewTaskWithResultT(func() (int, error) {
+			return lazy.Get(context.Background())
+		})
+	}
+	results, err := WaitAllT(context.Background(), tasks...)
+	if err != nil {
+		t.Errorf(`err = %v`, err)
+	}
+	for _, r := range results {
+		if r != 123 {
+			t.Errorf(`got %v, expected 123`, r)
+		}
+	}
+	if count.Load() != 1 || !lazy.IsValueCreated() {
+		t.Errorf(`factory called %d times, expected 1`, count.Load())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewAsyncLazy(func(ctx context.Context) (int, error) {
+		time.Sleep(time.Second)
+		return 0, nil
+	})
+	if _, err := slow.Get(ctx); err != context.Canceled {
+		t.Errorf(`err = %v, expected context.Canceled`, err)
+	}
+}
+
+func TestAsyncLazyRetryOnFailure(t *testing.T) {
+	count := 0
+	testErr := errors.New(`test error`)
+	factory := func(ctx context.Context) (int, error) {
+		count++
+		if count == 1 {
+			return 0, testErr
+		}
+		return count, nil
+	}
+
+	lazy := NewAsyncLazy(factory)
+	lazy.Get(context.Background())
+	if _, err := lazy.Get(context.Background()); err != testErr {
+		t.Errorf(`error should be cached, got %v`, err)
+	}
+
+	count = 0
+	lazy = NewAsyncLazy(factory).RetryOnFailure()
+	if _, err := lazy.Get(context.Background()); err != testErr {
+		t.Errorf(`err = %v, expected test error`, err)
+	}
+	if r, err := lazy.Get(context.Background()); r != 2 || err != nil {
+		t.Errorf(`got %v %v, expected 2`, r, err)
+	}
+}
+
+func TestAsyncLazyScheduler(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	full := func(policy RejectPolicy) *TaskScheduler {
+		scheduler := NewTaskScheduler(1, 0, policy)
+		scheduler.Submit(func() { <-block })
+		return scheduler
+	}
+	defer SetDefaultTaskScheduler(nil)
+
+	var lazy *AsyncLazy[int]
+	lazy = NewAsyncLazy(func(ctx context.Context) (int, error) {
+		if lazy.IsValueCreated() { // 在调用者的go proc执行时不能持有锁
+			return 0, errors.New(`value created too early`)
+		}
+		return 1, nil
+	})
+
+	SetDefaultTaskScheduler(full(RejectPolicyReject))
+	if _, err := lazy.Get(context.Background()); err != TaskRejectedError {
+		t.Errorf(`err = %v, expected TaskRejectedError`, err)
+	}
+
+	SetDefaultTaskScheduler(full(RejectPolicyCallerRuns))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if r, err := lazy.Get(context.Background()); r != 1 || err != nil {
+			t.Errorf(`got %v %v after rejection, expected 1`, r, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf(`factory run by the caller deadlocked`)
+	}
+}
@@ -0,0 +1,283 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 时钟，用于定时器、Sleep和超时。测试时可以用FakeClock代替真实时间
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	Sleep(d time.Duration)
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type clockHolder struct {
+	Clock
+}
+
+var defaultClock atomic.Pointer[clockHolder]
+
+func init() {
+	defaultClock.Store(&clockHolder{RealClock()})
+}
+
+// 设置Sleep、SelectChans、WaitTimeout以及定时器默认使用的时钟，nil表示恢复真实时间
+func SetClock(clock Clock) {
+	if clock == nil {
+		clock = RealClock()
+	}
+	defaultClock.Store(&clockHolder{clock})
+}
+
+func DefaultClock() Clock {
+	return defaultClock.Load().Clock
+}
+
+/*
+ *	真实时间
+ */
+
+type realClock struct{}
+
+func RealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (me realTimer) C() <-chan time.Time {
+	return me.timer.C
+}
+
+func (me realTimer) Stop() bool {
+	return me.timer.Stop()
+}
+
+func (me realTimer) Reset(d time.Duration) bool {
+	return me.timer.Reset(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (me realTicker) C() <-chan time.Time {
+	return me.ticker.C
+}
+
+func (me realTicker) Stop() {
+	me.ticker.Stop()
+}
+
+func (me realTicker) Reset(d time.Duration) {
+	me.ticker.Reset(d)
+}
+
+/*
+ *	测试用的时钟，只有调用Advance/Set才会前进
+ */
+
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	result := &FakeClock{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+	result.cond = sync.NewCond(&result.mu)
+	return result
+}
+
+func (me *FakeClock) Now() time.Time {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.now
+}
+
+func (me *FakeClock) NewTimer(d time.Duration) Timer {
+	return me.newTimer(d, 0)
+}
+
+func (me *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic(`non-positive interval for NewTicker`)
+	}
+	return fakeTicker{me.newTimer(d, d)}
+}
+
+// 阻塞直到其他go proc调用Advance/Set让时间经过d
+func (me *FakeClock) Sleep(d time.Duration) {
+	<-me.NewTimer(d).C()
+}
+
+func (me *FakeClock) newTimer(d, period time.Duration) *fakeTimer {
+	timer := &fakeTimer{
+		clock:  me,
+		c:      make(chan time.Time, 1),
+		period: period,
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.schedule(timer, d)
+	return timer
+}
+
+func (me *FakeClock) schedule(timer *fakeTimer, d time.Duration) {
+	timer.deadline = me.now.Add(d)
+	me.timers[timer] = struct{}{}
+	me.cond.Broadcast()
+	if d <= 0 {
+		me.fire(timer)
+	}
+}
+
+func (me *FakeClock) remove(timer *fakeTimer) bool {
+	if _, ok := me.timers[timer]; !ok {
+		return false
+	}
+	delete(me.timers, timer)
+	me.cond.Broadcast()
+	return true
+}
+
+// 和真实的timer一样，接收方来不及读取的时候丢弃
+func (me *FakeClock) fire(timer *fakeTimer) {
+	select {
+	case timer.c <- me.now:
+	default:
+	}
+	if timer.period > 0 {
+		timer.deadline = timer.deadline.Add(timer.period)
+	} else {
+		me.remove(timer)
+	}
+}
+
+// 时间前进d，按到期时刻的顺序触发到期的timer和ticker
+func (me *FakeClock) Advance(d time.Duration) {
+	me.mu.Lock()
+	target := me.now.Add(d)
+	me.mu.Unlock()
+	me.Set(target)
+}
+
+// 时间前进到t，t早于当前时间则什么都不做
+func (me *FakeClock) Set(t time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for {
+		var earliest *fakeTimer
+		for timer := range me.timers {
+			if !timer.deadline.After(t) && (earliest == nil || timer.deadline.Before(earliest.deadline)) {
+				earliest = timer
+			}
+		}
+		if earliest == nil {
+			break
+		}
+		if earliest.deadline.After(me.now) {
+			me.now = earliest.deadline
+		}
+		me.fire(earliest)
+	}
+	if t.After(me.now) {
+		me.now = t
+	}
+}
+
+// 还没到期的timer和ticker数量
+func (me *FakeClock) PendingTimers() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return len(me.timers)
+}
+
+// 阻塞直到还没到期的timer和ticker数量至少为n，用于等待其他go proc创建好timer再Advance
+func (me *FakeClock) BlockUntil(n int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for len(me.timers) < n {
+		me.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (me *fakeTimer) C() <-chan time.Time {
+	return me.c
+}
+
+func (me *fakeTimer) Stop() bool {
+	me.clock.mu.Lock()
+	defer me.clock.mu.Unlock()
+	return me.clock.remove(me)
+}
+
+func (me *fakeTimer) Reset(d time.Duration) bool {
+	me.clock.mu.Lock()
+	defer me.clock.mu.Unlock()
+	active := me.clock.remove(me)
+	if me.period > 0 {
+		if d <= 0 {
+			panic(`non-positive interval for Ticker.Reset`)
+		}
+		me.period = d
+	}
+	me.clock.schedule(me, d)
+	return active
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (me fakeTicker) Stop() {
+	me.fakeTimer.Stop()
+}
+
+func (me fakeTicker) Reset(d time.Duration) {
+	me.fakeTimer.Reset(d)
+}
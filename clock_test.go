@@ -0,0 +1,111 @@
+package common
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	t1 := clock.NewTimer(time.Second)
+	t2 := clock.NewTimer(3 * time.Second)
+	ticker := clock.NewTicker(2 * time.Second)
+	if n := clock.PendingTimers(); n != 3 {
+		t.Errorf(`pending = %d`, n)
+	}
+
+	clock.Advance(2 * time.Second)
+	if v := <-t1.C(); !v.Equal(start.Add(time.Second)) {
+		t.Errorf(`t1 fired at %v`, v)
+	}
+	if v := <-ticker.C(); !v.Equal(start.Add(2 * time.Second)) {
+		t.Errorf(`ticker fired at %v`, v)
+	}
+	select {
+	case <-t2.C():
+		t.Errorf(`t2 fired too early`)
+	default:
+	}
+	if n := clock.PendingTimers(); n != 2 {
+		t.Errorf(`pending = %d`, n)
+	}
+
+	if !t2.Stop() || t2.Stop() {
+		t.Errorf(`Stop should report whether the timer was active`)
+	}
+	clock.Advance(2 * time.Second)
+	if v := <-ticker.C(); !v.Equal(start.Add(4 * time.Second)) {
+		t.Errorf(`ticker fired at %v`, v)
+	}
+	ticker.Stop()
+	if n := clock.PendingTimers(); n != 0 {
+		t.Errorf(`pending = %d`, n)
+	}
+	if !clock.Now().Equal(start.Add(4 * time.Second)) {
+		t.Errorf(`now = %v`, clock.Now())
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	SetClock(clock)
+	defer SetClock(nil)
+
+	done := make(chan error)
+	go func() {
+		done <- Sleep(time.Hour)
+	}()
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Errorf(`Sleep returned before the clock advanced`)
+	default:
+	}
+	clock.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf(`Sleep: %v`, err)
+	}
+
+	tcs := NewTaskCompletionSourceT[int]()
+	go func() {
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+	}()
+	if err := tcs.WaitTimeout(time.Minute); err != context.DeadlineExceeded {
+		t.Errorf(`WaitTimeout: %v`, err)
+	}
+
+	ch := make(chan int)
+	go func() {
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+	}()
+	if which, _, _ := SelectChans(time.Second, ch); which != 1 {
+		t.Errorf(`SelectChans: which = %d`, which)
+	}
+	if n := clock.PendingTimers(); n != 0 {
+		t.Errorf(`pending = %d`, n)
+	}
+}
+
+func TestIntervalFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var count atomic.Int32
+	handle := SetInterval(time.Hour, func() { count.Add(1) }).WithClock(clock).Run()
+	for i := 1; i <= 5; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour)
+		for count.Load() != int32(i) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	handle.Stop()
+	<-handle.Done()
+	if n := clock.PendingTimers(); n != 0 {
+		t.Errorf(`pending = %d`, n)
+	}
+}
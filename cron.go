@@ -0,0 +1,368 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ *	cron表达式定时器
+ */
+
+// 解析后的cron表达式
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	domStar, dowStar                      bool // 日/星期字段是否为*，两者都不为*时满足任一即可
+	location                              *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSeconds = cronField{0, 59, nil}
+	cronMinutes = cronField{0, 59, nil}
+	cronHours   = cronField{0, 23, nil}
+	cronDoms    = cronField{1, 31, nil}
+	cronMonths  = cronField{1, 12, map[string]int{
+		`jan`: 1, `feb`: 2, `mar`: 3, `apr`: 4, `may`: 5, `jun`: 6,
+		`jul`: 7, `aug`: 8, `sep`: 9, `oct`: 10, `nov`: 11, `dec`: 12,
+	}}
+	cronDows = cronField{0, 7, map[string]int{ // 0和7都表示星期日
+		`sun`: 0, `mon`: 1, `tue`: 2, `wed`: 3, `thu`: 4, `fri`: 5, `sat`: 6,
+	}}
+
+	cronMacros = map[string]string{
+		`@yearly`:   `0 0 0 1 1 *`,
+		`@annually`: `0 0 0 1 1 *`,
+		`@monthly`:  `0 0 0 1 * *`,
+		`@weekly`:   `0 0 0 * * 0`,
+		`@daily`:    `0 0 0 * * *`,
+		`@midnight`: `0 0 0 * * *`,
+		`@hourly`:   `0 0 * * * *`,
+	}
+)
+
+// 解析cron表达式，支持：
+// 5个字段(分 时 日 月 星期)或6个字段(秒 分 时 日 月 星期)；
+// 每个字段支持 * ? a a-b */n a-b/n a/n 以及用逗号分隔的列表，月份和星期支持英文缩写(JAN, MON)；
+// @yearly @monthly @weekly @daily @hourly 等宏；
+// 以 CRON_TZ=Asia/Shanghai 或 TZ=Asia/Shanghai 开头指定时区，否则使用time.Local
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	loc := time.Local
+	if strings.HasPrefix(spec, `CRON_TZ=`) || strings.HasPrefix(spec, `TZ=`) {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf(`cron: missing fields after time zone: %q`, spec)
+		}
+		var err error
+		if loc, err = time.LoadLocation(spec[strings.Index(spec, `=`)+1 : i]); err != nil {
+			return nil, fmt.Errorf(`cron: %w`, err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{`0`}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf(`cron: expected 5 or 6 fields, got %d: %q`, len(fields), spec)
+	}
+
+	result := &CronSchedule{
+		location: loc,
+		domStar:  fields[3] == `*` || fields[3] == `?`,
+		dowStar:  fields[5] == `*` || fields[5] == `?`,
+	}
+	var err error
+	for i, p := range []*uint64{&result.second, &result.minute, &result.hour, &result.dom, &result.month, &result.dow} {
+		field := []cronField{cronSeconds, cronMinutes, cronHours, cronDoms, cronMonths, cronDows}[i]
+		if *p, err = field.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	if result.dow&(1<<7) != 0 {
+		result.dow |= 1
+	}
+	return result, nil
+}
+
+func (me cronField) parse(s string) (uint64, error) {
+	var result uint64
+	for _, part := range strings.Split(s, `,`) {
+		bits, err := me.parsePart(part)
+		if err != nil {
+			return 0, fmt.Errorf(`cron: invalid field %q: %w`, s, err)
+		}
+		result |= bits
+	}
+	return result, nil
+}
+
+func (me cronField) parsePart(s string) (uint64, error) {
+	rangeStr, stepStr, hasStep := strings.Cut(s, `/`)
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+			return 0, fmt.Errorf(`invalid step %q`, stepStr)
+		}
+	}
+
+	var low, high int
+	if rangeStr == `*` || rangeStr == `?` {
+		low, high = me.min, me.max
+	} else {
+		lowStr, highStr, isRange := strings.Cut(rangeStr, `-`)
+		var err error
+		if low, err = me.value(lowStr); err != nil {
+			return 0, err
+		}
+		switch {
+		case isRange:
+			if high, err = me.value(highStr); err != nil {
+				return 0, err
+			}
+		case hasStep: // a/n 表示从a到最大值
+			high = me.max
+		default:
+			high = low
+		}
+	}
+	if low > high {
+		return 0, fmt.Errorf(`invalid range %d-%d`, low, high)
+	}
+
+	var result uint64
+	for i := low; i <= high; i += step {
+		result |= 1 << uint(i)
+	}
+	return result, nil
+}
+
+func (me cronField) value(s string) (int, error) {
+	if v, ok := me.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid value %q`, s)
+	}
+	if v < me.min || v > me.max {
+		return 0, fmt.Errorf(`value %d out of range [%d, %d]`, v, me.min, me.max)
+	}
+	return v, nil
+}
+
+func (me *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := me.dom&(1<<uint(t.Day())) != 0
+	dowMatch := me.dow&(1<<uint(t.Weekday())) != 0
+	if me.domStar || me.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// 返回t之后(不包括t)第一个满足表达式的时刻，5年内都没有则返回零值。
+// 按表达式时区的墙上时间计算：夏令时跳过的时刻不会触发；
+// 夏令时结束时重复的墙上时间，指定了小时的表达式只触发一次，小时为*的表达式两次都会触发
+func (me *CronSchedule) Next(t time.Time) time.Time {
+	result := me.next(t)
+	if me.hour != cronAllHours {
+		for wall := wallClock(t.In(me.location)); !result.IsZero() && !wallClock(result).After(wall); {
+			result = me.next(result)
+		}
+	}
+	return result
+}
+
+const cronAllHours = 1<<24 - 1
+
+// 把墙上时间转换成UTC时间，用于比较
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+func (me *CronSchedule) next(t time.Time) time.Time {
+	loc := me.location
+	t = t.In(loc).Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+	added := false
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for me.month&(1<<uint(t.Month())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !me.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		// 夏令时切换可能让零点不存在，AddDate之后修正回当天开始
+		if h := t.Hour(); h != 0 {
+			if h > 12 {
+				t = t.Add(time.Duration(24-h) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-h) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for me.hour&(1<<uint(t.Hour())) == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for me.minute&(1<<uint(t.Minute())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for me.second&(1<<uint(t.Second())) == 0 {
+		if !added {
+			added = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+// 返回after之后的n个触发时刻
+func (me *CronSchedule) NextTimes(after time.Time, n int) []time.Time {
+	result := make([]time.Time, 0, n)
+	for t := after; len(result) < n; {
+		if t = me.Next(t); t.IsZero() {
+			break
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// 与SetIntervalTask相同的用法，按cron表达式定时执行
+type SetCronTask struct {
+	schedule    *CronSchedule
+	callback    TimerCallback
+	ctx         context.Context
+	cleanupFunc func()
+
+	runInCurrentGoProc bool
+}
+
+// cron定时器，表达式格式见ParseCron。如果程序收到退出信号，定时器会自动取消
+func SetCron(spec string, callback TimerCallback) (*SetCronTask, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &SetCronTask{
+		schedule: schedule,
+		callback: callback,
+		ctx:      context.Background(),
+	}, nil
+}
+
+func (me *SetCronTask) WithContext(ctx context.Context, cleanupFunc func()) *SetCronTask {
+	me.ctx = ctx
+	me.cleanupFunc = cleanupFunc
+	return me
+}
+
+// 使用指定时区计算触发时刻，覆盖表达式中的CRON_TZ
+func (me *SetCronTask) InLocation(loc *time.Location) *SetCronTask {
+	schedule := *me.schedule
+	schedule.location = loc
+	me.schedule = &schedule
+	return me
+}
+
+// 从现在开始的n个触发时刻
+func (me *SetCronTask) NextTimes(n int) []time.Time {
+	return me.schedule.NextTimes(time.Now(), n)
+}
+
+func (me *SetCronTask) RunInCurrentGoProc() {
+	me.runInCurrentGoProc = true
+	me.Run()
+}
+
+func (me *SetCronTask) Run() {
+	fun := func() {
+		defer func() {
+			if me.cleanupFunc != nil {
+				me.cleanupFunc()
+			}
+		}()
+
+		next := me.schedule.Next(time.Now())
+		for !next.IsZero() {
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				me.callback()
+			case <-ProgramDone():
+				timer.Stop()
+				return
+			case <-me.ctx.Done():
+				timer.Stop()
+				return
+			}
+
+			// 回调执行时间过长时跳过错过的时刻
+			now := time.Now()
+			if next = me.schedule.Next(next); !next.IsZero() && next.Before(now) {
+				next = me.schedule.Next(now)
+			}
+		}
+	}
+
+	if !me.runInCurrentGoProc {
+		go fun()
+	} else {
+		fun()
+	}
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{`* * * *`, `60 * * * *`, `* * * * * * *`, `5-1 * * * *`, `*/0 * * * *`, `TZ=Nowhere/City * * * * *`} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf(`%q should be invalid`, spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc, err := time.LoadLocation(`America/New_York`)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		spec     string
+		from     string
+		expected string
+	}{
+		{`5 0 * * *`, `2024-01-01 00:05:00`, `2024-01-02 00:05:00`},
+		{`*/15 * * * *`, `2024-01-01 10:07:30`, `2024-01-01 10:15:00`},
+		{`0 30 9 * * MON-FRI`, `2024-01-05 10:00:00`, `2024-01-08 09:30:00`},
+		{`0 0 1 1 *`, `2024-06-01 00:00:00`, `2025-01-01 00:00:00`},
+		{`0 0 29 2 *`, `2024-03-01 00:00:00`, `2028-02-29 00:00:00`},
+		{`0 12 13 * 5`, `2024-01-01 00:00:00`, `2024-01-05 12:00:00`}, // 日和星期满足任一
+		{`@hourly`, `2024-01-01 10:07:30`, `2024-01-01 11:00:00`},
+		{`30 2 * * *`, `2024-03-09 03:00:00`, `2024-03-11 02:30:00`}, // 2024-03-10 02:30 因夏令时不存在
+		{`30 1 * * *`, `2024-11-03 00:00:00`, `2024-11-03 01:30:00`},
+	}
+	for _, c := range cases {
+		schedule, err := ParseCron(`CRON_TZ=America/New_York ` + c.spec)
+		if err != nil {
+			t.Errorf(`%q: %v`, c.spec, err)
+			continue
+		}
+		from, _ := time.ParseInLocation(`2006-01-02 15:04:05`, c.from, loc)
+		if next := schedule.Next(from).Format(`2006-01-02 15:04:05`); next != c.expected {
+			t.Errorf(`%q from %s: got %s, expected %s`, c.spec, c.from, next, c.expected)
+		}
+	}
+
+	// 重复的时刻只触发一次
+	schedule, _ := ParseCron(`CRON_TZ=America/New_York 30 1 * * *`)
+	from, _ := time.ParseInLocation(`2006-01-02 15:04:05`, `2024-11-03 00:00:00`, loc)
+	times := schedule.NextTimes(from, 2)
+	if times[1].Sub(times[0]) != 25*time.Hour {
+		t.Errorf(`got %v, expected 25h apart`, times)
+	}
+}
+
+func TestSetCron(t *testing.T) {
+	ctx := NewCancelCtx(context.Background())
+	count := 0
+	task, err := SetCron(`* * * * * *`, func() {
+		count++
+		if count >= 2 {
+			ctx.Cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if times := task.NextTimes(3); len(times) != 3 || times[1].Sub(times[0]) != time.Second {
+		t.Errorf(`NextTimes = %v`, times)
+	}
+	start := time.Now()
+	task.WithContext(ctx, nil).RunInCurrentGoProc()
+	if count != 2 || time.Since(start) > 3*time.Second {
+		t.Errorf(`count = %d, elapsed %v`, count, time.Since(start))
+	}
+}
@@ -0,0 +1,546 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+ *	数据流块：BufferBlock / TransformBlock / ActionBlock / BatchBlock
+ *	块之间用LinkTo连接，输入缓冲区有界(背压)，完成和错误可以沿连接传递
+ */
+
+var (
+	BlockCompletedError = errors.New(`dataflow block completed`)
+)
+
+// 可以接收数据的块
+type TargetBlock[T any] interface {
+	// 不等待，缓冲区满或块已完成时返回false
+	Post(v T) bool
+	// 等待直到数据被接受。块已完成返回BlockCompletedError，块出错返回错误，收到程序退出信号返回ProgramExitingError
+	Send(ctx context.Context, v T) error
+	// 不再接受新数据，已接受的数据处理完后块结束
+	Complete()
+	// 以err结束块，丢弃未处理的数据
+	Fault(err error)
+	Completion() *TaskT[struct{}]
+}
+
+// 可以输出数据的块
+type SourceBlock[T any] interface {
+	// 把输出连接到target，返回断开连接的函数。propagateCompletion表示本块结束时让target也结束(Complete或Fault)
+	LinkTo(target TargetBlock[T], propagateCompletion bool) (unlink func())
+	// 等待并读取一个输出，块已结束返回BlockCompletedError
+	Receive(ctx context.Context) (T, error)
+	TryReceive() (T, bool)
+	Completion() *TaskT[struct{}]
+}
+
+type BlockOptions struct {
+	Capacity    int // 缓冲区大小，<=0表示1
+	Parallelism int // 同时处理的数据数量，<=0表示1。>1时TransformBlock的输出顺序与输入顺序不一定相同
+}
+
+func (me BlockOptions) capacity() int {
+	if me.Capacity <= 0 {
+		return 1
+	}
+	return me.Capacity
+}
+
+func (me BlockOptions) parallelism() int {
+	if me.Parallelism <= 0 {
+		return 1
+	}
+	return me.Parallelism
+}
+
+// 块的结束和出错状态
+type blockCore struct {
+	name       string
+	faultOnce  sync.Once
+	faultErr   error
+	faultCh    chan struct{}
+	completion *TaskCompletionSourceT[struct{}]
+}
+
+func newBlockCore(name string) *blockCore {
+	return &blockCore{
+		name:       name,
+		faultCh:    make(chan struct{}),
+		completion: NewTaskCompletionSourceT[struct{}](),
+	}
+}
+
+func (me *blockCore) fault(err error) {
+	me.faultOnce.Do(func() {
+		me.faultErr = err
+		close(me.faultCh)
+	})
+}
+
+func (me *blockCore) faulted() bool {
+	select {
+	case <-me.faultCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (me *blockCore) finish() {
+	if me.faulted() {
+		me.completion.SetError(me.faultErr)
+	} else {
+		me.completion.SetResult(struct{}{})
+	}
+}
+
+// 执行用户函数，把错误和panic转换成块的错误
+func (me *blockCore) call(fun func() error) bool {
+	err := func() (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = NewPanicError(e)
+			}
+		}()
+		return fun()
+	}()
+	if err != nil {
+		me.fault(NewError(me.name, err))
+		return false
+	}
+	return true
+}
+
+// 块的输入端
+type blockInput[T any] struct {
+	*blockCore
+	in         chan T
+	closeInput func() // 所有Send结束后调用，关闭in
+
+	mu        sync.Mutex
+	declining bool
+	senders   sync.WaitGroup
+}
+
+func newBlockInput[T any](core *blockCore, capacity int) *blockInput[T] {
+	result := &blockInput[T]{
+		blockCore: core,
+		in:        make(chan T, capacity),
+	}
+	result.closeInput = func() { close(result.in) }
+	return result
+}
+
+func (me *blockInput[T]) enter() bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.declining {
+		return false
+	}
+	me.senders.Add(1)
+	return true
+}
+
+func (me *blockInput[T]) Post(v T) bool {
+	if !me.enter() {
+		return false
+	}
+	defer me.senders.Done()
+	select {
+	case me.in <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+func (me *blockInput[T]) Send(ctx context.Context, v T) error {
+	if !me.enter() {
+		return BlockCompletedError
+	}
+	defer me.senders.Done()
+	select {
+	case me.in <- v:
+		return nil
+	case <-me.faultCh:
+		return me.faultErr
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ProgramDone():
+		return ProgramExitingError
+	}
+}
+
+func (me *blockInput[T]) Complete() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.declining {
+		return
+	}
+	me.declining = true
+	go func() {
+		me.senders.Wait()
+		me.closeInput()
+	}()
+}
+
+func (me *blockInput[T]) Fault(err error) {
+	me.fault(err)
+	me.Complete()
+}
+
+type blockLink[T any] struct {
+	target              TargetBlock[T]
+	propagateCompletion bool
+}
+
+// 块的输出端
+type blockOutput[T any] struct {
+	*blockCore
+	out chan T
+
+	mu          sync.Mutex
+	links       []*blockLink[T] // copy on write
+	linkChanged chan struct{}   // 连接变化时close并替换
+	pumping     bool
+	finished    bool
+}
+
+func newBlockOutput[T any](core *blockCore, capacity int) *blockOutput[T] {
+	return &blockOutput[T]{
+		blockCore:   core,
+		out:         make(chan T, capacity),
+		linkChanged: make(chan struct{}),
+	}
+}
+
+// 不再有新的输出。出错时丢弃未读取的输出并马上结束
+func (me *blockOutput[T]) closeOutput() {
+	close(me.out)
+	if me.faulted() {
+		for range me.out {
+		}
+		me.finishOutput()
+	}
+}
+
+// 输出已经全部读取，结束块并传递给连接的目标
+func (me *blockOutput[T]) finishOutput() {
+	me.mu.Lock()
+	if me.finished {
+		me.mu.Unlock()
+		return
+	}
+	me.finished = true
+	links := me.links
+	me.mu.Unlock()
+
+	me.finish()
+	for _, link := range links {
+		me.propagate(link)
+	}
+}
+
+func (me *blockOutput[T]) propagate(link *blockLink[T]) {
+	if !link.propagateCompletion {
+		return
+	}
+	if me.faulted() {
+		link.target.Fault(me.faultErr)
+	} else {
+		link.target.Complete()
+	}
+}
+
+func (me *blockOutput[T]) LinkTo(target TargetBlock[T], propagateCompletion bool) func() {
+	link := &blockLink[T]{target, propagateCompletion}
+
+	me.mu.Lock()
+	if me.finished {
+		me.mu.Unlock()
+		me.propagate(link)
+		return func() {}
+	}
+	me.links = append(me.links[:len(me.links):len(me.links)], link)
+	me.signalLinkChanged()
+	if !me.pumping {
+		me.pumping = true
+		go me.pump()
+	}
+	me.mu.Unlock()
+
+	return func() { me.unlink(link) }
+}
+
+func (me *blockOutput[T]) unlink(link *blockLink[T]) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for i, l := range me.links {
+		if l == link {
+			links := make([]*blockLink[T], 0, len(me.links)-1)
+			links = append(links, me.links[:i]...)
+			me.links = append(links, me.links[i+1:]...)
+			me.signalLinkChanged()
+			return
+		}
+	}
+}
+
+// 调用前须持有me.mu
+func (me *blockOutput[T]) signalLinkChanged() {
+	close(me.linkChanged)
+	me.linkChanged = make(chan struct{})
+}
+
+// 把输出转发给连接的目标
+func (me *blockOutput[T]) pump() {
+	for v := range me.out {
+		if me.faulted() {
+			continue
+		}
+		if !me.deliver(v) {
+			return
+		}
+	}
+	me.finishOutput()
+}
+
+// 依次尝试各个目标，都不接受时等待第一个目标。收到程序退出信号返回false
+func (me *blockOutput[T]) deliver(v T) bool {
+	for {
+		me.mu.Lock()
+		links := me.links
+		changed := me.linkChanged
+		me.mu.Unlock()
+
+		if len(links) == 0 {
+			select {
+			case <-changed:
+				continue
+			case <-ProgramDone():
+				return false
+			}
+		}
+
+		for _, link := range links {
+			if link.target.Post(v) {
+				return true
+			}
+		}
+		switch err := links[0].target.Send(context.Background(), v); err {
+		case nil:
+			return true
+		case ProgramExitingError:
+			return false
+		default: // 目标已结束，不会再接受数据
+			me.unlink(links[0])
+		}
+	}
+}
+
+func (me *blockOutput[T]) Receive(ctx context.Context) (T, error) {
+	select {
+	case v, ok := <-me.out:
+		if !ok {
+			me.finishOutput()
+			return v, BlockCompletedError
+		}
+		return v, nil
+	case <-ctx.Done():
+		var v T
+		return v, ctx.Err()
+	case <-ProgramDone():
+		var v T
+		return v, ProgramExitingError
+	}
+}
+
+func (me *blockOutput[T]) TryReceive() (T, bool) {
+	select {
+	case v, ok := <-me.out:
+		if !ok {
+			me.finishOutput()
+		}
+		return v, ok
+	default:
+		var v T
+		return v, false
+	}
+}
+
+// 启动n个处理协程，全部结束后调用onExit
+func startProcessors(n int, process func(), onExit func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			process()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		onExit()
+	}()
+}
+
+/*
+ *	BufferBlock 缓存数据，供Receive读取或转发给连接的目标
+ */
+
+type BufferBlock[T any] struct {
+	*blockInput[T]
+	*blockOutput[T]
+}
+
+func NewBufferBlock[T any](options BlockOptions) *BufferBlock[T] {
+	core := newBlockCore(`BufferBlock`)
+	input := newBlockInput[T](core, options.capacity())
+	output := &blockOutput[T]{
+		blockCore:   core,
+		out:         input.in,
+		linkChanged: make(chan struct{}),
+	}
+	input.closeInput = output.closeOutput
+	return &BufferBlock[T]{input, output}
+}
+
+func (me *BufferBlock[T]) Completion() *TaskT[struct{}] {
+	return &me.blockInput.completion.TaskT
+}
+
+/*
+ *	TransformBlock 对每个输入调用fun，输出其结果。fun返回错误时块以BaseError结束
+ */
+
+type TransformBlock[T, U any] struct {
+	*blockInput[T]
+	*blockOutput[U]
+}
+
+func NewTransformBlock[T, U any](fun func(T) (U, error), options BlockOptions) *TransformBlock[T, U] {
+	core := newBlockCore(`TransformBlock`)
+	result := &TransformBlock[T, U]{
+		newBlockInput[T](core, options.capacity()),
+		newBlockOutput[U](core, options.capacity()),
+	}
+	startProcessors(options.parallelism(), func() {
+		for v := range result.in {
+			if core.faulted() {
+				continue
+			}
+			var u U
+			if !core.call(func() (err error) {
+				u, err = fun(v)
+				return
+			}) {
+				result.blockInput.Complete()
+				continue
+			}
+			select {
+			case result.out <- u:
+			case <-core.faultCh:
+			}
+		}
+	}, result.closeOutput)
+	return result
+}
+
+func (me *TransformBlock[T, U]) Completion() *TaskT[struct{}] {
+	return &me.blockInput.completion.TaskT
+}
+
+/*
+ *	ActionBlock 对每个输入调用fun，没有输出。fun返回错误时块以BaseError结束
+ */
+
+type ActionBlock[T any] struct {
+	*blockInput[T]
+}
+
+func NewActionBlock[T any](fun func(T) error, options BlockOptions) *ActionBlock[T] {
+	core := newBlockCore(`ActionBlock`)
+	result := &ActionBlock[T]{
+		newBlockInput[T](core, options.capacity()),
+	}
+	startProcessors(options.parallelism(), func() {
+		for v := range result.in {
+			if core.faulted() {
+				continue
+			}
+			if !core.call(func() error { return fun(v) }) {
+				result.Complete()
+			}
+		}
+	}, core.finish)
+	return result
+}
+
+func (me *ActionBlock[T]) Completion() *TaskT[struct{}] {
+	return &me.completion.TaskT
+}
+
+/*
+ *	BatchBlock 把输入按batchSize个一组输出，结束时输出剩余不足一组的数据
+ */
+
+type BatchBlock[T any] struct {
+	*blockInput[T]
+	*blockOutput[[]T]
+	trigger chan struct{}
+}
+
+// options.Capacity为输出缓冲区能容纳的批数，输入缓冲区为batchSize
+func NewBatchBlock[T any](batchSize int, options BlockOptions) *BatchBlock[T] {
+	if batchSize <= 0 {
+		panic(`batchSize must be > 0`)
+	}
+	core := newBlockCore(`BatchBlock`)
+	result := &BatchBlock[T]{
+		newBlockInput[T](core, batchSize),
+		newBlockOutput[[]T](core, options.capacity()),
+		make(chan struct{}, 1),
+	}
+	startProcessors(1, func() {
+		batch := make([]T, 0, batchSize)
+		emit := func() {
+			if len(batch) == 0 || core.faulted() {
+				return
+			}
+			select {
+			case result.out <- batch:
+			case <-core.faultCh:
+			}
+			batch = make([]T, 0, batchSize)
+		}
+		for {
+			select {
+			case v, ok := <-result.in:
+				if !ok {
+					emit()
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) >= batchSize {
+					emit()
+				}
+			case <-result.trigger:
+				emit()
+			}
+		}
+	}, result.closeOutput)
+	return result
+}
+
+// 马上输出当前不足一组的数据
+func (me *BatchBlock[T]) TriggerBatch() {
+	TryWriteChan(me.trigger, struct{}{})
+}
+
+func (me *BatchBlock[T]) Completion() *TaskT[struct{}] {
+	return &me.blockInput.completion.TaskT
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	_ TargetBlock[int]    = (*BufferBlock[int])(nil)
+	_ SourceBlock[int]    = (*BufferBlock[int])(nil)
+	_ TargetBlock[int]    = (*TransformBlock[int, string])(nil)
+	_ SourceBlock[string] = (*TransformBlock[int, string])(nil)
+	_ TargetBlock[int]    = (*ActionBlock[int])(nil)
+	_ SourceBlock[[]int]  = (*BatchBlock[int])(nil)
+)
+
+func TestDataflowPipeline(t *testing.T) {
+	buffer := NewBufferBlock[int](BlockOptions{Capacity: 10})
+	double := NewTransformBlock(func(v int) (int, error) {
+		return v * 2, nil
+	}, BlockOptions{Capacity: 2, Parallelism: 4})
+	var mu sync.Mutex
+	sum := 0
+	action := NewActionBlock(func(v int) error {
+		mu.Lock()
+		sum += v
+		mu.Unlock()
+		return nil
+	}, BlockOptions{})
+
+	buffer.LinkTo(double, true)
+	double.LinkTo(action, true)
+	for i := 1; i <= 100; i++ {
+		if err := buffer.Send(context.Background(), i); err != nil {
+			t.Fatalf(`Send: %v`, err)
+		}
+	}
+	buffer.Complete()
+	if err := action.Completion().WaitTimeout(5 * time.Second); err != nil {
+		t.Fatalf(`pipeline did not complete`)
+	}
+	if _, err := action.Completion().GetResult(); err != nil {
+		t.Errorf(`err = %v`, err)
+	}
+	if sum != 10100 {
+		t.Errorf(`sum = %d, expected 10100`, sum)
+	}
+	if buffer.Post(1) {
+		t.Errorf(`completed block should decline`)
+	}
+}
+
+func TestDataflowFault(t *testing.T) {
+	testErr := errors.New(`test error`)
+	transform := NewTransformBlock(func(v int) (int, error) {
+		if v == 3 {
+			return 0, testErr
+		}
+		return v, nil
+	}, BlockOptions{Capacity: 10})
+	action := NewActionBlock(func(v int) error { return nil }, BlockOptions{})
+	transform.LinkTo(action, true)
+	for i := 1; i <= 5; i++ {
+		transform.Post(i)
+	}
+	if err := action.Completion().WaitTimeout(5 * time.Second); err != nil {
+		t.Fatalf(`fault not propagated`)
+	}
+	_, err := action.Completion().GetResult()
+	var base BaseError
+	if !errors.As(err, &base) || base.Err != testErr {
+		t.Errorf(`err = %v, expected BaseError wrapping test error`, err)
+	}
+	if _, err := transform.Completion().GetResult(); err == nil {
+		t.Errorf(`transform should be faulted`)
+	}
+}
+
+func TestBatchBlock(t *testing.T) {
+	batch := NewBatchBlock[int](3, BlockOptions{Capacity: 10})
+	for i := 0; i < 7; i++ {
+		batch.Send(context.Background(), i)
+	}
+	batch.Complete()
+	sizes := []int{}
+	for {
+		b, err := batch.Receive(context.Background())
+		if err == BlockCompletedError {
+			break
+		}
+		sizes = append(sizes, len(b))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf(`batch sizes = %v, expected [3 3 1]`, sizes)
+	}
+	if !batch.Completion().IsDone() {
+		t.Errorf(`batch block should be completed`)
+	}
+}
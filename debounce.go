@@ -0,0 +1,188 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ *	防抖和节流
+ */
+
+// Debouncer 把一段时间内的多次Call合并成一次fn调用，由Debounce和Throttle创建。
+// 选项必须在第一次Call之前设置。程序收到退出信号时，未执行的调用被丢弃，之后的Call不再执行fn
+type Debouncer struct {
+	mu       sync.Mutex
+	fn       func()
+	wait     time.Duration
+	maxWait  time.Duration // 0表示不限制
+	leading  bool
+	trailing bool
+	clock    Clock
+
+	stop       chan struct{} // 当前这一轮的go proc，nil表示空闲
+	pending    bool          // 结束时是否需要执行fn
+	burstStart time.Time     // 这一轮开始或者上一次因为maxWait执行的时刻
+	lastCall   time.Time
+}
+
+// 最后一次Call之后d时间内没有新的Call，才执行fn(trailing)
+func Debounce(d time.Duration, fn func()) *Debouncer {
+	return &Debouncer{
+		fn:       fn,
+		wait:     d,
+		trailing: true,
+		clock:    DefaultClock(),
+	}
+}
+
+// 第一次Call马上执行fn(leading)，之后每d时间最多执行一次，最后一次Call在结束时执行(trailing)
+func Throttle(d time.Duration, fn func()) *Debouncer {
+	return &Debouncer{
+		fn:       fn,
+		wait:     d,
+		maxWait:  d,
+		leading:  true,
+		trailing: true,
+		clock:    DefaultClock(),
+	}
+}
+
+// 每一轮的第一次Call是否马上在调用者的go proc执行fn
+func (me *Debouncer) WithLeading(leading bool) *Debouncer {
+	me.leading = leading
+	return me
+}
+
+// 每一轮结束时，如果有没执行的Call，是否执行fn
+func (me *Debouncer) WithTrailing(trailing bool) *Debouncer {
+	me.trailing = trailing
+	return me
+}
+
+// 持续Call的时候，最多等待d就执行一次fn
+func (me *Debouncer) WithMaxWait(d time.Duration) *Debouncer {
+	me.maxWait = d
+	return me
+}
+
+// 使用指定的时钟，默认DefaultClock()
+func (me *Debouncer) WithClock(clock Clock) *Debouncer {
+	me.clock = clock
+	return me
+}
+
+func (me *Debouncer) Call() {
+	if IsProgramDone() {
+		return
+	}
+
+	me.mu.Lock()
+	me.lastCall = me.clock.Now()
+	if me.stop != nil {
+		me.pending = me.trailing
+		me.mu.Unlock()
+		return
+	}
+	me.stop = make(chan struct{})
+	me.burstStart = me.lastCall
+	me.pending = !me.leading && me.trailing
+	go me.loop(me.stop, me.clock.NewTimer(me.nextDeadline().Sub(me.lastCall)))
+	me.mu.Unlock()
+
+	if me.leading {
+		callbackNoPanic(me.fn)
+	}
+}
+
+// 有没执行的Call则马上执行fn，然后结束这一轮
+func (me *Debouncer) Flush() {
+	me.mu.Lock()
+	invoke := me.stop != nil && me.pending
+	me.finish()
+	me.mu.Unlock()
+
+	if invoke {
+		callbackNoPanic(me.fn)
+	}
+}
+
+// 丢弃没执行的Call，结束这一轮
+func (me *Debouncer) Cancel() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.finish()
+}
+
+// 是否有还没执行的Call
+func (me *Debouncer) Pending() bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.stop != nil && me.pending
+}
+
+func (me *Debouncer) finish() {
+	if me.stop != nil {
+		close(me.stop)
+		me.stop = nil
+	}
+	me.pending = false
+}
+
+func (me *Debouncer) nextDeadline() time.Time {
+	deadline := me.lastCall.Add(me.wait)
+	if me.maxWait > 0 {
+		if maxDeadline := me.burstStart.Add(me.maxWait); maxDeadline.Before(deadline) {
+			return maxDeadline
+		}
+	}
+	return deadline
+}
+
+func (me *Debouncer) loop(stop chan struct{}, timer Timer) {
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C():
+		case <-stop:
+			return
+		case <-ProgramDone():
+			me.mu.Lock()
+			if me.stop == stop {
+				me.finish()
+			}
+			me.mu.Unlock()
+			return
+		}
+
+		me.mu.Lock()
+		if me.stop != stop {
+			me.mu.Unlock()
+			return
+		}
+		now := me.clock.Now()
+		if deadline := me.nextDeadline(); now.Before(deadline) { // 期间有新的Call，继续等
+			timer.Reset(deadline.Sub(now))
+			me.mu.Unlock()
+			continue
+		}
+
+		invoke := me.pending
+		me.pending = false
+		end := !now.Before(me.lastCall.Add(me.wait))
+		if end {
+			me.stop = nil
+		} else { // 到了maxWait，执行一次之后开始新的计时
+			me.burstStart = now
+			timer.Reset(me.nextDeadline().Sub(now))
+		}
+		me.mu.Unlock()
+
+		if invoke {
+			callbackNoPanic(me.fn)
+		}
+		if end {
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	calls := make(chan time.Time, 10)
+	start := clock.Now()
+	debouncer := Debounce(100*time.Millisecond, func() {
+		calls <- clock.Now()
+	}).WithClock(clock)
+
+	debouncer.Call()
+	for i := 0; i < 2; i++ {
+		clock.Advance(50 * time.Millisecond)
+		debouncer.Call()
+		if i > 0 {
+			clock.BlockUntil(1)
+		}
+	}
+	clock.Advance(50 * time.Millisecond)
+	clock.BlockUntil(1)
+	if len(calls) != 0 || !debouncer.Pending() {
+		t.Errorf(`fn called before the quiet period`)
+	}
+	clock.Advance(50 * time.Millisecond)
+	if at := <-calls; at.Sub(start) != 200*time.Millisecond {
+		t.Errorf(`fn called at %v`, at.Sub(start))
+	}
+
+	debouncer.Call()
+	debouncer.Flush()
+	if len(calls) != 1 || debouncer.Pending() {
+		t.Errorf(`Flush should call fn immediately`)
+	}
+	<-calls
+	debouncer.Call()
+	debouncer.Cancel()
+	clock.Advance(time.Second)
+	if len(calls) != 0 || debouncer.Pending() {
+		t.Errorf(`Cancel should drop the pending call`)
+	}
+
+	count := 0
+	leading := Debounce(100*time.Millisecond, func() { count++ }).WithLeading(true).WithTrailing(false).WithClock(clock)
+	leading.Call()
+	leading.Call()
+	if count != 1 || leading.Pending() {
+		t.Errorf(`leading count = %d`, count)
+	}
+	leading.Cancel()
+	leading.Call()
+	if count != 2 {
+		t.Errorf(`leading count = %d`, count)
+	}
+	leading.Cancel()
+}
+
+func TestThrottle(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	calls := make(chan struct{}, 10)
+	throttle := Throttle(100*time.Millisecond, func() {
+		calls <- struct{}{}
+	}).WithClock(clock)
+
+	throttle.Call()
+	if len(calls) != 1 {
+		t.Errorf(`leading call missing`)
+	}
+	for i := 0; i < 25; i++ {
+		clock.Advance(10 * time.Millisecond)
+		if clock.PendingTimers() == 0 {
+			clock.BlockUntil(1)
+		}
+		throttle.Call()
+	}
+	// 100ms、200ms各执行一次，250ms的Call在300ms执行，350ms结束
+	clock.Advance(50 * time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		select {
+		case <-calls:
+		case <-time.After(time.Second):
+			t.Fatalf(`expected 4 calls, got %d`, i)
+		}
+	}
+	select {
+	case <-calls:
+		t.Errorf(`too many calls`)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	maxWait := make(chan struct{}, 10)
+	debouncer := Debounce(100*time.Millisecond, func() {
+		maxWait <- struct{}{}
+	}).WithMaxWait(250 * time.Millisecond).WithClock(clock)
+	debouncer.Call()
+	for i := 0; i < 30; i++ {
+		clock.Advance(10 * time.Millisecond)
+		if clock.PendingTimers() == 0 {
+			clock.BlockUntil(1)
+		}
+		debouncer.Call()
+	}
+	debouncer.Cancel()
+	select {
+	case <-maxWait:
+	case <-time.After(time.Second):
+		t.Errorf(`maxWait call missing`)
+	}
+	if n := len(maxWait); n != 0 {
+		t.Errorf(`too many maxWait calls: %d`, n+1)
+	}
+}
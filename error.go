@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
@@ -102,3 +104,57 @@ var (
 func PanicWithTime(v interface{}) {
 	panic(fmt.Errorf(`%s - %v`, time.Now().Format(`2006-01-02 15:04:05`), v))
 }
+
+// PanicError 由recover到的panic转换而来，Stack为panic发生处的调用栈，可以用errors.As检测
+type PanicError struct {
+	BaseError
+	Value interface{}
+}
+
+var panicHandler atomic.Pointer[func(*PanicError)]
+
+// 设置全局panic回调，任务中发生panic时都会调用，可用于上报。nil表示取消回调
+func SetPanicHandler(handler func(*PanicError)) {
+	if handler == nil {
+		panicHandler.Store(nil)
+	} else {
+		panicHandler.Store(&handler)
+	}
+}
+
+var errorHandler atomic.Pointer[func(error)]
+
+// 设置全局错误回调，定时器回调返回的错误没有用OnError处理时调用，可用于上报。nil表示取消回调
+func SetErrorHandler(handler func(error)) {
+	if handler == nil {
+		errorHandler.Store(nil)
+	} else {
+		errorHandler.Store(&handler)
+	}
+}
+
+// NewPanicError 将recover()的返回值包装成PanicError，并调用全局panic回调。
+// 必须在defer的函数里调用，才能记录panic发生处的调用栈
+func NewPanicError(v interface{}) *PanicError {
+	err, ok := v.(error)
+	if !ok {
+		err = fmt.Errorf(`%v`, v)
+	}
+	result := &PanicError{
+		BaseError: BaseError{`panic`, err, string(debug.Stack())},
+		Value:     v,
+	}
+	if handler := panicHandler.Load(); handler != nil {
+		(*handler)(result)
+	}
+	return result
+}
+
+// As 让errors.As(err, &BaseError{})也能匹配PanicError，与recover之前的NewErrorf行为一致
+func (me *PanicError) As(target interface{}) bool {
+	if base, ok := target.(*BaseError); ok {
+		*base = me.BaseError
+		return true
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+)
+
+/*
+ *	并发处理切片
+ */
+
+func parallelMap[T, U any](ctx context.Context, items []T, limit int, collectAll bool, fn func(ctx context.Context, item T) (U, error)) ([]U, error) {
+	g := NewTaskGroup(ctx)
+	if limit > 0 {
+		g.WithLimit(limit)
+	}
+	if collectAll {
+		g.CollectAll()
+	}
+
+	results := make([]U, len(items))
+	var stopped error // 没有启动全部任务的原因
+	for i := range items {
+		if err := g.ctx.Err(); err != nil { // 已经失败或被取消，不再启动新的任务
+			stopped = err
+			break
+		}
+		i := i
+		g.Go(func(ctx context.Context) (err error) {
+			results[i], err = fn(ctx, items[i])
+			return
+		})
+	}
+	err := g.Wait()
+	if err == nil && stopped != nil { // 已经启动的任务都成功了，但还有没启动的
+		switch {
+		case IsProgramDone():
+			err = ProgramExitingError
+		case ctx.Err() != nil:
+			err = ctx.Err()
+		default:
+			err = stopped
+		}
+	}
+	return results, err
+}
+
+// 并发对items调用fn，最多同时执行limit个(<=0表示不限制)，按输入顺序返回结果。
+// 任一调用失败即取消其余调用并返回该错误；ctx结束或收到程序退出信号时也会取消
+func ParallelMap[T, U any](ctx context.Context, items []T, limit int, fn func(ctx context.Context, item T) (U, error)) ([]U, error) {
+	results, err := parallelMap(ctx, items, limit, false, fn)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// 与ParallelMap相同，但失败不会取消其余调用。返回所有结果(失败项为零值)和所有错误的合并
+func ParallelMapAll[T, U any](ctx context.Context, items []T, limit int, fn func(ctx context.Context, item T) (U, error)) ([]U, error) {
+	return parallelMap(ctx, items, limit, true, fn)
+}
+
+// 并发对items调用fn，最多同时执行limit个(<=0表示不限制)。任一调用失败即取消其余调用并返回该错误
+func ParallelForEach[T any](ctx context.Context, items []T, limit int, fn func(ctx context.Context, item T) error) error {
+	_, err := parallelMap(ctx, items, limit, false, func(ctx context.Context, item T) (struct{}, error) {
+		return struct{}{}, fn(ctx, item)
+	})
+	return err
+}
+
+// 与ParallelForEach相同，但失败不会取消其余调用，返回所有错误的合并
+func ParallelForEachAll[T any](ctx context.Context, items []T, limit int, fn func(ctx context.Context, item T) error) error {
+	_, err := parallelMap(ctx, items, limit, true, func(ctx context.Context, item T) (struct{}, error) {
+		return struct{}{}, fn(ctx, item)
+	})
+	return err
+}
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelMap(t *testing.T) {
+	items := []int{5, 4, 3, 2, 1}
+	var tracker concurrencyTracker
+	results, err := ParallelMap(context.Background(), items, 2, func(ctx context.Context, item int) (int, error) {
+		defer tracker.enter()()
+		time.Sleep(time.Duration(item) * 10 * time.Millisecond)
+		return item * 10, nil
+	})
+	if err != nil {
+		t.Errorf(`err = %v`, err)
+	}
+	for i, r := range results {
+		if r != items[i]*10 {
+			t.Errorf(`results = %v, expected in input order`, results)
+			break
+		}
+	}
+	if tracker.max.Load() != 2 {
+		t.Errorf(`max running = %d, expected 2`, tracker.max.Load())
+	}
+}
+
+func TestParallelForEachError(t *testing.T) {
+	testErr := errors.New(`test error`)
+	var count atomic.Int32
+	err := ParallelForEach(context.Background(), make([]int, 100), 1, func(ctx context.Context, item int) error {
+		if count.Add(1) == 3 {
+			return testErr
+		}
+		return nil
+	})
+	if err != testErr {
+		t.Errorf(`err = %v, expected test error`, err)
+	}
+	if count.Load() >= 100 {
+		t.Errorf(`should stop early, called %d times`, count.Load())
+	}
+
+	count.Store(0)
+	err = ParallelForEachAll(context.Background(), make([]int, 10), 3, func(ctx context.Context, item int) error {
+		if count.Add(1)%2 == 0 {
+			return testErr
+		}
+		return nil
+	})
+	if !errors.Is(err, testErr) || count.Load() != 10 {
+		t.Errorf(`err = %v, count = %d, expected all 10 called`, err, count.Load())
+	}
+}
+
+func TestParallelMapCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var count atomic.Int32
+	results, err := ParallelMap(ctx, []int{1, 2, 3}, 0, func(ctx context.Context, item int) (int, error) {
+		count.Add(1)
+		return item, nil
+	})
+	if err != context.Canceled || results != nil {
+		t.Errorf(`results = %v, err = %v, expected context.Canceled`, results, err)
+	}
+	if count.Load() != 0 {
+		t.Errorf(`fn called %d times on a canceled context`, count.Load())
+	}
+
+	err = ParallelForEachAll(ctx, []int{1, 2, 3}, 1, func(ctx context.Context, item int) error {
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf(`err = %v, expected context.Canceled`, err)
+	}
+}
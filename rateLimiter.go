@@ -0,0 +1,383 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+ *	限流
+ */
+
+var (
+	RateLimitExceededError = errors.New(`requested tokens exceed limiter capacity`)
+)
+
+// 限流器。TokenBucket和SlidingWindow都实现了这个接口
+type RateLimiter interface {
+	// 等待直到获得1个令牌。ctx取消时返回ctx.Err()，程序收到退出信号时返回ProgramExitingError
+	Wait(ctx context.Context) error
+	// 等待直到获得n个令牌，n超过容量时马上返回RateLimitExceededError
+	WaitN(ctx context.Context, n int) error
+	// 不等待，马上能获得n个令牌则返回true
+	TryAcquire(n int) bool
+	// 预留n个令牌，返回可以使用的时刻。不再需要时调用Reservation.Cancel归还
+	Reserve(n int) *Reservation
+}
+
+// 预留的令牌
+type Reservation struct {
+	ok     bool
+	at     time.Time
+	clock  Clock
+	cancel func()
+	once   sync.Once
+}
+
+// n超过容量时为false，此时没有预留任何令牌
+func (me *Reservation) OK() bool {
+	return me.ok
+}
+
+// 可以使用令牌的时刻
+func (me *Reservation) Time() time.Time {
+	return me.at
+}
+
+// 距离可以使用令牌还需要等待的时间，0表示马上可以使用
+func (me *Reservation) Delay() time.Duration {
+	if d := me.at.Sub(me.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// 归还还没到使用时刻的令牌，已经到了使用时刻则什么都不做
+func (me *Reservation) Cancel() {
+	if !me.ok {
+		return
+	}
+	me.once.Do(me.cancel)
+}
+
+// 等待直到预留的令牌可以使用
+func (me *Reservation) wait(ctx context.Context) error {
+	if !me.ok {
+		return RateLimitExceededError
+	}
+	delay := me.Delay()
+	if delay == 0 {
+		return nil
+	}
+	timer := me.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		me.Cancel()
+		return ctx.Err()
+	case <-ProgramDone():
+		me.Cancel()
+		return ProgramExitingError
+	}
+}
+
+func waitN(ctx context.Context, limiter RateLimiter, n int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ProgramDone():
+		return ProgramExitingError
+	default:
+	}
+	return limiter.Reserve(n).wait(ctx)
+}
+
+/*
+ *	令牌桶：以rate个/秒的速度产生令牌，最多积攒burst个
+ */
+
+type TokenBucket struct {
+	mu     sync.Mutex
+	clock  Clock
+	rate   float64
+	burst  int
+	tokens float64   // 可以为负数，表示已经被预留
+	last   time.Time // tokens的计算时刻
+}
+
+// rate: 每秒产生的令牌数，必须>0
+// burst: 桶的容量，初始是满的
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 || burst <= 0 {
+		panic(`rate and burst must be > 0`)
+	}
+	clock := DefaultClock()
+	return &TokenBucket{
+		clock:  clock,
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// 使用指定的时钟，默认DefaultClock()。必须在使用之前调用
+func (me *TokenBucket) WithClock(clock Clock) *TokenBucket {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.clock = clock
+	me.last = clock.Now()
+	return me
+}
+
+// 修改产生令牌的速度，已经积攒的令牌不受影响
+func (me *TokenBucket) SetRate(rate float64) {
+	if rate <= 0 {
+		panic(`rate must be > 0`)
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.advance(me.clock.Now())
+	me.rate = rate
+}
+
+// 修改桶的容量，多出的令牌被丢弃
+func (me *TokenBucket) SetBurst(burst int) {
+	if burst <= 0 {
+		panic(`burst must be > 0`)
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.advance(me.clock.Now())
+	me.burst = burst
+	if me.tokens > float64(burst) {
+		me.tokens = float64(burst)
+	}
+}
+
+func (me *TokenBucket) Rate() float64 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.rate
+}
+
+func (me *TokenBucket) Burst() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.burst
+}
+
+// 当前可用的令牌数，负数表示已经被预留
+func (me *TokenBucket) Tokens() float64 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.advance(me.clock.Now())
+	return me.tokens
+}
+
+func (me *TokenBucket) advance(now time.Time) {
+	if elapsed := now.Sub(me.last); elapsed > 0 {
+		me.tokens += elapsed.Seconds() * me.rate
+		if me.tokens > float64(me.burst) {
+			me.tokens = float64(me.burst)
+		}
+		me.last = now
+	}
+}
+
+func (me *TokenBucket) Wait(ctx context.Context) error {
+	return me.WaitN(ctx, 1)
+}
+
+func (me *TokenBucket) WaitN(ctx context.Context, n int) error {
+	return waitN(ctx, me, n)
+}
+
+func (me *TokenBucket) TryAcquire(n int) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.advance(me.clock.Now())
+	if n > me.burst || me.tokens < float64(n) {
+		return false
+	}
+	me.tokens -= float64(n)
+	return true
+}
+
+func (me *TokenBucket) Reserve(n int) *Reservation {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	now := me.clock.Now()
+	if n > me.burst {
+		return &Reservation{clock: me.clock, at: now}
+	}
+	me.advance(now)
+	me.tokens -= float64(n)
+	at := now
+	if me.tokens < 0 {
+		at = now.Add(time.Duration(-me.tokens / me.rate * float64(time.Second)))
+	}
+	return &Reservation{
+		ok:    true,
+		at:    at,
+		clock: me.clock,
+		cancel: func() {
+			me.mu.Lock()
+			defer me.mu.Unlock()
+			now := me.clock.Now()
+			if at.After(now) {
+				me.advance(now)
+				me.tokens += float64(n)
+				if me.tokens > float64(me.burst) {
+					me.tokens = float64(me.burst)
+				}
+			}
+		},
+	}
+}
+
+/*
+ *	滑动窗口：任意window时间内最多获得limit个令牌
+ */
+
+type SlidingWindow struct {
+	mu      sync.Mutex
+	clock   Clock
+	limit   int
+	window  time.Duration
+	entries []*windowEntry // 按时刻排序，可以包含预留的未来时刻
+	count   int            // entries中令牌的总数
+}
+
+type windowEntry struct {
+	at time.Time
+	n  int
+}
+
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	if limit <= 0 || window <= 0 {
+		panic(`limit and window must be > 0`)
+	}
+	return &SlidingWindow{
+		clock:  DefaultClock(),
+		limit:  limit,
+		window: window,
+	}
+}
+
+// 使用指定的时钟，默认DefaultClock()。必须在使用之前调用
+func (me *SlidingWindow) WithClock(clock Clock) *SlidingWindow {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.clock = clock
+	return me
+}
+
+// 修改窗口内允许的令牌数
+func (me *SlidingWindow) SetLimit(limit int) {
+	if limit <= 0 {
+		panic(`limit must be > 0`)
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.limit = limit
+}
+
+// 修改窗口长度
+func (me *SlidingWindow) SetWindow(window time.Duration) {
+	if window <= 0 {
+		panic(`window must be > 0`)
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.window = window
+}
+
+func (me *SlidingWindow) Limit() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.limit
+}
+
+func (me *SlidingWindow) Window() time.Duration {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.window
+}
+
+// 删除已经移出窗口的记录
+func (me *SlidingWindow) prune(now time.Time) {
+	i := 0
+	for ; i < len(me.entries) && !me.entries[i].at.Add(me.window).After(now); i++ {
+		me.count -= me.entries[i].n
+	}
+	me.entries = me.entries[i:]
+}
+
+func (me *SlidingWindow) Wait(ctx context.Context) error {
+	return me.WaitN(ctx, 1)
+}
+
+func (me *SlidingWindow) WaitN(ctx context.Context, n int) error {
+	return waitN(ctx, me, n)
+}
+
+func (me *SlidingWindow) TryAcquire(n int) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	now := me.clock.Now()
+	me.prune(now)
+	if n > me.limit || me.count+n > me.limit {
+		return false
+	}
+	me.entries = append(me.entries, &windowEntry{now, n})
+	me.count += n
+	return true
+}
+
+func (me *SlidingWindow) Reserve(n int) *Reservation {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	now := me.clock.Now()
+	if n > me.limit {
+		return &Reservation{clock: me.clock, at: now}
+	}
+	me.prune(now)
+
+	// 找到足够多的记录移出窗口的时刻
+	at := now
+	for i, remain := 0, me.count; remain+n > me.limit; i++ {
+		remain -= me.entries[i].n
+		at = me.entries[i].at.Add(me.window)
+	}
+	if last := len(me.entries) - 1; last >= 0 && me.entries[last].at.After(at) {
+		at = me.entries[last].at // 保持entries有序
+	}
+
+	entry := &windowEntry{at, n}
+	me.entries = append(me.entries, entry)
+	me.count += n
+	return &Reservation{
+		ok:    true,
+		at:    at,
+		clock: me.clock,
+		cancel: func() {
+			me.mu.Lock()
+			defer me.mu.Unlock()
+			if !entry.at.After(me.clock.Now()) {
+				return
+			}
+			for i, e := range me.entries {
+				if e == entry {
+					me.entries = append(me.entries[:i], me.entries[i+1:]...)
+					me.count -= n
+					return
+				}
+			}
+		},
+	}
+}
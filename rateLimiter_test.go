@@ -0,0 +1,110 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	bucket := NewTokenBucket(10, 5).WithClock(clock)
+
+	if !bucket.TryAcquire(5) || bucket.TryAcquire(1) {
+		t.Errorf(`burst should allow exactly 5 tokens`)
+	}
+	if bucket.TryAcquire(6) {
+		t.Errorf(`n > burst should fail`)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if !bucket.TryAcquire(1) || bucket.TryAcquire(1) {
+		t.Errorf(`100ms at 10/s should give 1 token`)
+	}
+
+	r := bucket.Reserve(2)
+	if !r.OK() || r.Delay() != 200*time.Millisecond {
+		t.Errorf(`reserve delay = %v`, r.Delay())
+	}
+	r.Cancel()
+	clock.Advance(100 * time.Millisecond)
+	if !bucket.TryAcquire(1) {
+		t.Errorf(`canceled reservation should return tokens`)
+	}
+	if r := bucket.Reserve(6); r.OK() {
+		t.Errorf(`n > burst should not be reserved`)
+	}
+
+	bucket.SetRate(100)
+	done := make(chan error)
+	go func() {
+		done <- bucket.WaitN(context.Background(), 3)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Errorf(`WaitN: %v`, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- bucket.WaitN(ctx, 5)
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf(`WaitN after cancel: %v`, err)
+	}
+	if err := bucket.WaitN(context.Background(), 6); err != RateLimitExceededError {
+		t.Errorf(`WaitN(6): %v`, err)
+	}
+
+	bucket.SetBurst(2)
+	clock.Advance(time.Second)
+	if tokens := bucket.Tokens(); tokens != 2 {
+		t.Errorf(`tokens = %v`, tokens)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	window := NewSlidingWindow(3, time.Second).WithClock(clock)
+
+	if !window.TryAcquire(2) {
+		t.Errorf(`TryAcquire(2) failed`)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !window.TryAcquire(1) || window.TryAcquire(1) {
+		t.Errorf(`window should allow exactly 3 tokens`)
+	}
+
+	r := window.Reserve(2)
+	if !r.OK() || r.Delay() != 500*time.Millisecond {
+		t.Errorf(`reserve delay = %v`, r.Delay())
+	}
+	r2 := window.Reserve(1)
+	if r2.Delay() != time.Second {
+		t.Errorf(`reserve delay = %v`, r2.Delay())
+	}
+	r.Cancel()
+	r2.Cancel()
+
+	clock.Advance(500 * time.Millisecond)
+	if !window.TryAcquire(2) || window.TryAcquire(1) {
+		t.Errorf(`first 2 tokens should have left the window`)
+	}
+
+	window.SetLimit(4)
+	if !window.TryAcquire(1) {
+		t.Errorf(`SetLimit should take effect immediately`)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- window.Wait(context.Background())
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Errorf(`Wait: %v`, err)
+	}
+}
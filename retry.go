@@ -0,0 +1,177 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+ *	失败重试
+ */
+
+// 退避策略。attempt为已经失败的次数(从1开始)，prev为上一次的等待时间(第一次为0)，返回下一次重试前的等待时间
+type Backoff interface {
+	Next(attempt int, prev time.Duration) time.Duration
+}
+
+type BackoffFunc func(attempt int, prev time.Duration) time.Duration
+
+func (f BackoffFunc) Next(attempt int, prev time.Duration) time.Duration {
+	return f(attempt, prev)
+}
+
+// 固定间隔
+func ConstantBackoff(interval time.Duration) Backoff {
+	return BackoffFunc(func(int, time.Duration) time.Duration {
+		return interval
+	})
+}
+
+// 指数退避：base * factor^(attempt-1)，最大不超过max(max<=0表示不限制)
+func ExponentialBackoff(base, max time.Duration, factor float64) Backoff {
+	return BackoffFunc(func(attempt int, _ time.Duration) time.Duration {
+		d := float64(base) * math.Pow(factor, float64(attempt-1))
+		if max > 0 && d > float64(max) {
+			return max
+		}
+		if d > math.MaxInt64 {
+			return time.Duration(math.MaxInt64)
+		}
+		return time.Duration(d)
+	})
+}
+
+// Decorrelated jitter退避：在[base, prev*3)之间随机，最大不超过max
+func DecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	return BackoffFunc(func(_ int, prev time.Duration) time.Duration {
+		if prev < base {
+			prev = base
+		}
+		upper := prev * 3
+		d := base
+		if upper > base {
+			d += time.Duration(rand.Int63n(int64(upper - base)))
+		}
+		if max > 0 && d > max {
+			return max
+		}
+		return d
+	})
+}
+
+type RetryPolicy struct {
+	backoff     Backoff
+	maxAttempts int
+	maxElapsed  time.Duration
+	retryable   func(error) bool
+}
+
+// 默认无限重试，所有错误都重试
+func NewRetryPolicy(backoff Backoff) *RetryPolicy {
+	return &RetryPolicy{
+		backoff: backoff,
+	}
+}
+
+// 最多执行n次(包括第一次)，<=0表示不限制
+func (me *RetryPolicy) WithMaxAttempts(n int) *RetryPolicy {
+	me.maxAttempts = n
+	return me
+}
+
+// 从第一次执行开始计时，超过d之后不再重试，<=0表示不限制
+func (me *RetryPolicy) WithMaxElapsed(d time.Duration) *RetryPolicy {
+	me.maxElapsed = d
+	return me
+}
+
+// f返回false的错误不再重试
+func (me *RetryPolicy) WithRetryable(f func(error) bool) *RetryPolicy {
+	me.retryable = f
+	return me
+}
+
+// 放弃重试时返回，包含每一次执行的错误；如果是因为ctx结束或程序退出而放弃，最后一个错误为ctx.Err()或ProgramExitingError，
+// NewRetryingTaskT被取消时为ErrTaskCanceled
+type RetryError struct {
+	Errors []error
+}
+
+func (me *RetryError) Error() string {
+	return fmt.Sprintf(`gave up after %d attempts: %v`, me.attempts(), me.Errors)
+}
+
+func (me *RetryError) Unwrap() []error {
+	return me.Errors
+}
+
+func (me *RetryError) attempts() int {
+	n := len(me.Errors)
+	if n > 0 {
+		if last := me.Errors[n-1]; last == ProgramExitingError || last == ErrTaskCanceled || last == context.Canceled || last == context.DeadlineExceeded {
+			n--
+		}
+	}
+	return n
+}
+
+// 按策略执行fun直到成功，失败时返回*RetryError
+func Retry[T any](ctx context.Context, policy *RetryPolicy, fun func(ctx context.Context) (T, error)) (T, error) {
+	return retry(ctx, policy, fun, nil)
+}
+
+// canceledErr不为nil时，ctx被取消后用它代替context.Canceled作为最后一个错误
+func retry[T any](ctx context.Context, policy *RetryPolicy, fun func(ctx context.Context) (T, error), canceledErr error) (T, error) {
+	var errs []error
+	var delay time.Duration
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		r, err := fun(ctx)
+		if err == nil {
+			return r, nil
+		}
+		errs = append(errs, err)
+
+		if policy.retryable != nil && !policy.retryable(err) {
+			return r, &RetryError{errs}
+		}
+		if policy.maxAttempts > 0 && attempt >= policy.maxAttempts {
+			return r, &RetryError{errs}
+		}
+		delay = policy.backoff.Next(attempt, delay)
+		if policy.maxElapsed > 0 && time.Since(start)+delay > policy.maxElapsed {
+			return r, &RetryError{errs}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			err := ctx.Err()
+			if canceledErr != nil && err == context.Canceled {
+				err = canceledErr
+			}
+			return r, &RetryError{append(errs, err)}
+		case <-ProgramDone():
+			timer.Stop()
+			return r, &RetryError{append(errs, ProgramExitingError)}
+		}
+	}
+}
+
+// 创建按策略重试的任务，Cancel()会中止重试。
+// 被取消时仍然以包含每一次执行错误的*RetryError结束，最后一个错误为ErrTaskCanceled，可用IsCanceled()判断
+func NewRetryingTaskT[T any](ctx context.Context, policy *RetryPolicy, fun func(ctx context.Context) (T, error)) *TaskT[T] {
+	return NewTaskCtxT(ctx, func(ctx context.Context) (T, error) {
+		r, err := retry(ctx, policy, fun, ErrTaskCanceled)
+		if retryErr, ok := err.(*RetryError); ok && ctx.Err() == context.Canceled && !errors.Is(err, ErrTaskCanceled) {
+			retryErr.Errors = append(retryErr.Errors, ErrTaskCanceled) // 取消时恰好放弃了重试
+		}
+		return r, err
+	})
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	count := 0
+	testErr := errors.New(`test error`)
+	policy := NewRetryPolicy(ConstantBackoff(10 * time.Millisecond)).WithMaxAttempts(5)
+	r, err := NewRetryingTaskT(context.Background(), policy, func(ctx context.Context) (int, error) {
+		count++
+		if count < 3 {
+			return 0, testErr
+		}
+		return count, nil
+	}).GetResult()
+	if err != nil || r != 3 {
+		t.Errorf(`got %v %v, expected 3`, r, err)
+	}
+
+	count = 0
+	_, err = Retry(context.Background(), policy, func(ctx context.Context) (int, error) {
+		count++
+		return 0, testErr
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || len(retryErr.Errors) != 5 || count != 5 {
+		t.Errorf(`err = %v, expected 5 attempts`, err)
+	}
+	if !errors.Is(err, testErr) {
+		t.Errorf(`err should wrap test error`)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	count := 0
+	testErr := errors.New(`test error`)
+	policy := NewRetryPolicy(ConstantBackoff(10 * time.Millisecond)).WithRetryable(func(err error) bool {
+		return err != testErr
+	})
+	Retry(context.Background(), policy, func(ctx context.Context) (int, error) {
+		count++
+		return 0, testErr
+	})
+	if count != 1 {
+		t.Errorf(`count = %d, expected 1`, count)
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	policy := NewRetryPolicy(ConstantBackoff(time.Hour))
+	task := NewRetryingTaskT(context.Background(), policy, func(ctx context.Context) (int, error) {
+		return 0, errors.New(`test error`)
+	})
+	time.Sleep(10 * time.Millisecond)
+	task.Cancel()
+	if err := task.WaitTimeout(time.Second); err != nil || !task.IsCanceled() {
+		t.Errorf(`task should be canceled promptly`)
+	}
+	_, err := task.GetResult()
+	if retryErr, ok := err.(*RetryError); !ok || len(retryErr.Errors) != 2 || retryErr.attempts() != 1 {
+		t.Errorf(`err = %v, expected RetryError with the attempt error`, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := ExponentialBackoff(time.Second, 5*time.Second, 2)
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if d := b.Next(i+1, 0); d != e {
+			t.Errorf(`attempt %d: %v, expected %v`, i+1, d, e)
+		}
+	}
+
+	b = DecorrelatedJitterBackoff(time.Second, 10*time.Second)
+	var prev time.Duration
+	for i := 1; i < 20; i++ {
+		prev = b.Next(i, prev)
+		if prev < time.Second || prev > 10*time.Second {
+			t.Errorf(`attempt %d: %v out of range`, i, prev)
+		}
+	}
+}
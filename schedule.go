@@ -0,0 +1,73 @@
+package common
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ *	异步的定时任务，可以取消
+ */
+
+// 定时执行的任务，Fired()表示回调是否已经开始执行
+type ScheduledTask[T any] struct {
+	*TaskT[T]
+	fired atomic.Bool
+}
+
+func (me *ScheduledTask[T]) Fired() bool {
+	return me.fired.Load()
+}
+
+// 在at时刻执行fn。触发前Cancel()、ctx结束则以ErrTaskCanceled结束且fn不会执行，收到程序退出信号则以ProgramExitingError结束；
+// 触发后Cancel()会取消传给fn的ctx
+func Schedule[T any](ctx context.Context, at time.Time, fn func(ctx context.Context) (T, error)) *ScheduledTask[T] {
+	c := NewCancelCtx(ctx)
+	result := &ScheduledTask[T]{
+		TaskT: newTaskT[T](),
+	}
+	result.cancelFunc = func() error {
+		c.Cancel()
+		return nil
+	}
+	go func() {
+		defer c.Cancel()
+
+		var r T
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-timer.C:
+		case <-c.Done():
+			timer.Stop()
+			result.complete(r, ErrTaskCanceled)
+			return
+		case <-ProgramDone():
+			timer.Stop()
+			result.complete(r, ProgramExitingError)
+			return
+		}
+
+		result.fired.Store(true)
+		result.run(func() (T, error) {
+			r, err := fn(c)
+			if err != nil && c.Err() == context.Canceled {
+				err = ErrTaskCanceled
+			}
+			return r, err
+		})
+	}()
+	return result
+}
+
+// 在d时间之后执行fn，见Schedule
+func ScheduleAfter[T any](ctx context.Context, d time.Duration, fn func(ctx context.Context) (T, error)) *ScheduledTask[T] {
+	return Schedule(ctx, time.Now().Add(d), fn)
+}
+
+// d时间之后成功结束的任务，可以Cancel()，见Schedule
+func Delay(ctx context.Context, d time.Duration) *TaskT[struct{}] {
+	return ScheduleAfter(ctx, d, func(context.Context) (struct{}, error) {
+		return struct{}{}, nil
+	}).TaskT
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	start := time.Now()
+	task := Delay(context.Background(), 50*time.Millisecond)
+	if task.IsDone() {
+		t.Errorf(`Delay should not block`)
+	}
+	if _, err := task.GetResult(); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf(`err = %v, elapsed %v`, err, time.Since(start))
+	}
+
+	task = Delay(context.Background(), time.Hour)
+	task.Cancel()
+	if err := task.WaitTimeout(time.Second); err != nil || !task.IsCanceled() {
+		t.Errorf(`Delay should be canceled`)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	called := false
+	task := ScheduleAfter(context.Background(), time.Hour, func(ctx context.Context) (int, error) {
+		called = true
+		return 0, nil
+	})
+	task.Cancel()
+	task.Wait()
+	if called || task.Fired() || !task.IsCanceled() {
+		t.Errorf(`canceled task should not fire`)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	task = Schedule(ctx, time.Now().Add(10*time.Millisecond), func(ctx context.Context) (int, error) {
+		return 123, nil
+	})
+	if r, err := task.GetResult(); r != 123 || err != nil || !task.Fired() {
+		t.Errorf(`got %v %v, fired = %v`, r, err, task.Fired())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	task = ScheduleAfter(ctx, time.Hour, func(ctx context.Context) (int, error) {
+		return 0, nil
+	})
+	cancel()
+	if err := task.WaitTimeout(time.Second); err != nil || !task.IsCanceled() {
+		t.Errorf(`ctx cancel should cancel the task`)
+	}
+}
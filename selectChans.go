@@ -6,7 +6,7 @@ import (
 )
 
 // 同时读取多个chan
-// timeout: 指定超时时间，<=0表示永不超时
+// timeout: 指定超时时间，<=0表示永不超时，使用DefaultClock()计时
 // which: 	第几个chan读取到了数据
 // value:	读取到的数据
 // ok: 		true=读取成功，false=chan已关闭
@@ -29,9 +29,11 @@ func SelectChans(timeout time.Duration, chans ...interface{}) (which int, value
 	}
 
 	if timeout > 0 {
-		set[length] = reflect.SelectCase{
+		timer := DefaultClock().NewTimer(timeout)
+		defer timer.Stop()
+		set[length-1] = reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(time.NewTimer(timeout).C),
+			Chan: reflect.ValueOf(timer.C()),
 		}
 	}
 
@@ -57,9 +59,11 @@ func WaitAllChans(timeout time.Duration, chans ...interface{}) bool {
 		}
 	}
 
+	timer := DefaultClock().NewTimer(timeout)
+	defer timer.Stop()
 	set[len(chans)] = reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
-		Chan: reflect.ValueOf(time.NewTimer(timeout).C),
+		Chan: reflect.ValueOf(timer.C()),
 	}
 
 	for len(set) > 1 {
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"errors"
+)
+
+/*
+ *	chan、回调风格的API与任务之间的转换
+ */
+
+var (
+	ChanClosedError = errors.New(`chan closed`)
+)
+
+// 从ch读取第一个值作为任务结果；ch被关闭则以ChanClosedError结束，收到程序退出信号则以ProgramExitingError结束。
+// Cancel()会停止读取并以ErrTaskCanceled结束
+func TaskFromChan[T any](ch <-chan T) *TaskT[T] {
+	result := NewTaskCompletionSourceT[T]()
+	canceled := NewEvent()
+	result.cancelFunc = func() error {
+		canceled.Set()
+		return nil
+	}
+	go func() {
+		select {
+		case v, ok := <-ch:
+			if ok {
+				result.SetResult(v)
+			} else {
+				result.SetError(ChanClosedError)
+			}
+		case <-canceled.Done():
+			result.SetCanceled()
+		case <-ProgramDone():
+			result.SetError(ProgramExitingError)
+		}
+	}()
+	return &result.TaskT
+}
+
+// 返回一个chan，任务结束时写入一次结果。每次调用都返回新的chan
+func (me *TaskT[T]) ResultChan() <-chan Result[T] {
+	ch := make(chan Result[T], 1)
+	me.onDone(func() {
+		ch <- Result[T]{me.result, me.err}
+	})
+	return ch
+}
+
+// 在当前go proc调用start，start(或它启动的异步操作)调用resolve/reject来结束任务，只有第一次调用有效。
+// start发生panic则以*PanicError结束
+func TaskFromFunc[T any](start func(resolve func(T), reject func(error))) *TaskT[T] {
+	result := NewTaskCompletionSourceT[T]()
+	result.startNoPanic(func() {
+		start(func(r T) { result.SetResult(r) }, func(e error) { result.SetError(e) })
+	})
+	return &result.TaskT
+}
+
+// 与TaskFromFunc相同，用于结果和错误由同一个回调返回的API
+func TaskFromCallback[T any](start func(callback func(T, error))) *TaskT[T] {
+	result := NewTaskCompletionSourceT[T]()
+	result.startNoPanic(func() {
+		start(func(r T, e error) { result.complete(r, e) })
+	})
+	return &result.TaskT
+}
+
+func (me *TaskCompletionSourceT[T]) startNoPanic(start func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			me.SetError(NewPanicError(err))
+		}
+	}()
+	start()
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTaskFromChan(t *testing.T) {
+	ch := make(chan int, 1)
+	task := TaskFromChan(ch)
+	ch <- 123
+	if r, err := task.GetResult(); r != 123 || err != nil {
+		t.Errorf(`got %v %v, expected 123`, r, err)
+	}
+
+	ch2 := make(chan int)
+	close(ch2)
+	if _, err := TaskFromChan(ch2).GetResult(); err != ChanClosedError {
+		t.Errorf(`err = %v, expected ChanClosedError`, err)
+	}
+
+	task = TaskFromChan(make(chan int))
+	task.Cancel()
+	if task.Wait(); !task.IsCanceled() {
+		t.Errorf(`task should be canceled`)
+	}
+}
+
+func TestResultChan(t *testing.T) {
+	c := NewTaskCompletionSourceT[int]()
+	ch := c.ResultChan()
+	c.SetResult(123)
+	select {
+	case r := <-ch:
+		if r.Value != 123 || r.Err != nil {
+			t.Errorf(`got %v, expected 123`, r)
+		}
+	case <-time.After(time.Second):
+		t.Errorf(`ResultChan timeout`)
+	}
+}
+
+func TestTaskFromFunc(t *testing.T) {
+	task := TaskFromFunc(func(resolve func(int), reject func(error)) {
+		time.AfterFunc(10*time.Millisecond, func() { resolve(123) })
+	})
+	if r, err := task.GetResult(); r != 123 || err != nil {
+		t.Errorf(`got %v %v, expected 123`, r, err)
+	}
+
+	testErr := errors.New(`test error`)
+	task = TaskFromCallback(func(callback func(int, error)) {
+		go callback(0, testErr)
+	})
+	if _, err := task.GetResult(); err != testErr {
+		t.Errorf(`err = %v, expected test error`, err)
+	}
+
+	task = TaskFromFunc(func(resolve func(int), reject func(error)) {
+		panic(`test panic`)
+	})
+	var pe *PanicError
+	if _, err := task.GetResult(); !errors.As(err, &pe) {
+		t.Errorf(`err = %v, expected PanicError`, err)
+	}
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+/*
+ *	任务组合：不阻塞调用者，返回新的任务，可以继续组合
+ */
+
+// 任务的结果和错误
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// 取消所有任务，返回所有Cancel()错误的合并
+func cancelAll[T any](tasks []*TaskT[T]) func() error {
+	return func() error {
+		var errs []error
+		for _, t := range tasks {
+			if err := t.Cancel(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// 所有任务成功后按顺序返回所有结果；任一任务失败则马上以该错误结束。取消返回的任务会取消所有任务
+func WhenAll[T any](tasks ...*TaskT[T]) *TaskT[[]T] {
+	result := NewTaskCompletionSourceT[[]T]()
+	result.cancelFunc = cancelAll(tasks)
+	if len(tasks) == 0 {
+		result.SetResult([]T{})
+		return &result.TaskT
+	}
+
+	var remaining atomic.Int32
+	remaining.Store(int32(len(tasks)))
+	for _, t := range tasks {
+		t := t
+		t.onDone(func() {
+			if t.err != nil {
+				result.SetError(t.err)
+				return
+			}
+			if remaining.Add(-1) == 0 {
+				results := make([]T, len(tasks))
+				for i, t := range tasks {
+					results[i] = t.result
+				}
+				result.SetResult(results)
+			}
+		})
+	}
+	return &result.TaskT
+}
+
+// 任一任务结束(无论成功还是失败)后返回它的序号
+func WhenAny[T any](tasks ...*TaskT[T]) *TaskT[int] {
+	result := NewTaskCompletionSourceT[int]()
+	result.cancelFunc = cancelAll(tasks)
+	if len(tasks) == 0 {
+		result.SetError(NewError(`WhenAny: no tasks`, nil))
+		return &result.TaskT
+	}
+
+	for i, t := range tasks {
+		i := i
+		t.onDone(func() {
+			result.SetResult(i)
+		})
+	}
+	return &result.TaskT
+}
+
+// 以最先结束的任务的结果和错误结束
+func Race[T any](tasks ...*TaskT[T]) *TaskT[T] {
+	result := NewTaskCompletionSourceT[T]()
+	result.cancelFunc = cancelAll(tasks)
+	if len(tasks) == 0 {
+		result.SetError(NewError(`Race: no tasks`, nil))
+		return &result.TaskT
+	}
+
+	for _, t := range tasks {
+		t := t
+		t.onDone(func() {
+			result.complete(t.result, t.err)
+		})
+	}
+	return &result.TaskT
+}
+
+// 所有任务结束后按顺序返回每个任务的结果和错误，返回的任务本身不会失败
+func AllSettled[T any](tasks ...*TaskT[T]) *TaskT[[]Result[T]] {
+	result := NewTaskCompletionSourceT[[]Result[T]]()
+	result.cancelFunc = cancelAll(tasks)
+	if len(tasks) == 0 {
+		result.SetResult([]Result[T]{})
+		return &result.TaskT
+	}
+
+	var remaining atomic.Int32
+	remaining.Store(int32(len(tasks)))
+	for _, t := range tasks {
+		t.onDone(func() {
+			if remaining.Add(-1) == 0 {
+				results := make([]Result[T], len(tasks))
+				for i, t := range tasks {
+					results[i] = Result[T]{t.result, t.err}
+				}
+				result.SetResult(results)
+			}
+		})
+	}
+	return &result.TaskT
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func delayedTask(d time.Duration, r int, err error) *TaskT[int] {
+	return NewTaskWithResultT(func() (int, error) {
+		time.Sleep(d)
+		return r, err
+	})
+}
+
+func TestWhenAll(t *testing.T) {
+	r, err := WhenAll(delayedTask(50*time.Millisecond, 1, nil), delayedTask(0, 2, nil)).GetResult()
+	if err != nil || len(r) != 2 || r[0] != 1 || r[1] != 2 {
+		t.Errorf(`got %v %v, expected [1 2]`, r, err)
+	}
+
+	testErr := errors.New(`test error`)
+	start := time.Now()
+	_, err = WhenAll(delayedTask(time.Second, 1, nil), delayedTask(0, 2, testErr)).GetResult()
+	if err != testErr || time.Since(start) > 500*time.Millisecond {
+		t.Errorf(`WhenAll should fail fast with test error, got %v`, err)
+	}
+
+	if r, err = WhenAll[int]().GetResult(); err != nil || len(r) != 0 {
+		t.Errorf(`empty WhenAll got %v %v`, r, err)
+	}
+}
+
+func TestWhenAnyRace(t *testing.T) {
+	testErr := errors.New(`test error`)
+	i, err := WhenAny(delayedTask(time.Second, 1, nil), delayedTask(0, 2, testErr)).GetResult()
+	if i != 1 || err != nil {
+		t.Errorf(`WhenAny got %v %v, expected 1`, i, err)
+	}
+
+	r, err := Race(delayedTask(time.Second, 1, nil), delayedTask(0, 2, testErr)).GetResult()
+	if r != 2 || err != testErr {
+		t.Errorf(`Race got %v %v, expected 2 test error`, r, err)
+	}
+}
+
+func TestAllSettled(t *testing.T) {
+	testErr := errors.New(`test error`)
+	r, err := AllSettled(delayedTask(50*time.Millisecond, 1, nil), delayedTask(0, 2, testErr)).GetResult()
+	if err != nil || len(r) != 2 {
+		t.Fatalf(`got %v %v`, r, err)
+	}
+	if r[0].Value != 1 || r[0].Err != nil || r[1].Value != 2 || r[1].Err != testErr {
+		t.Errorf(`got %v`, r)
+	}
+}
+
+func TestWhenAllCancel(t *testing.T) {
+	c := NewTaskCompletionSourceT[int]()
+	if err := WhenAll(&c.TaskT).Cancel(); err != nil {
+		t.Errorf(`Cancel: %v`, err)
+	}
+	if err := WhenAll(delayedTask(50*time.Millisecond, 1, nil), &c.TaskT).Cancel(); err == nil {
+		t.Errorf(`Cancel should report the task that cannot be canceled`)
+	}
+}
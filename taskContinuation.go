@@ -0,0 +1,70 @@
+package common
+
+import (
+	"sync/atomic"
+)
+
+/*
+ *	任务延续：源任务结束后才把后续函数提交到DefaultTaskScheduler()执行，等待期间不占用go proc
+ */
+
+// 源任务结束后执行f，无论成功还是失败。
+// 取消返回的任务会取消还没结束的源任务并返回其Cancel()的错误；f还没开始执行时，返回的任务以ErrTaskCanceled结束且f不会执行
+func ContinueWith[T, U any](t *TaskT[T], f func(t *TaskT[T]) (U, error)) *TaskT[U] {
+	result := newTaskT[U]()
+	var claimed atomic.Bool // f开始执行或者已经被取消
+	result.cancelFunc = func() error {
+		var err error
+		if !t.IsDone() {
+			err = t.Cancel()
+		}
+		if claimed.CompareAndSwap(false, true) {
+			var u U
+			result.complete(u, ErrTaskCanceled)
+		} else if err == nil && !result.IsDone() {
+			err = NewError(`This continuation is already running`, nil)
+		}
+		return err
+	}
+	t.onDone(func() {
+		result.submitTo(DefaultTaskScheduler(), func() {
+			if claimed.CompareAndSwap(false, true) {
+				result.run(func() (U, error) {
+					return f(t)
+				})
+			}
+		})
+	})
+	return result
+}
+
+// 源任务成功后用其结果执行f；源任务失败则直接把错误传递给返回的任务
+func Then[T, U any](t *TaskT[T], f func(T) (U, error)) *TaskT[U] {
+	return ContinueWith(t, func(t *TaskT[T]) (U, error) {
+		r, err := t.GetResult()
+		if err != nil {
+			var u U
+			return u, err
+		}
+		return f(r)
+	})
+}
+
+// 源任务失败后执行f，可以返回替代结果或新的错误；源任务成功则直接传递结果
+func Catch[T any](t *TaskT[T], f func(error) (T, error)) *TaskT[T] {
+	return ContinueWith(t, func(t *TaskT[T]) (T, error) {
+		r, err := t.GetResult()
+		if err != nil {
+			return f(err)
+		}
+		return r, nil
+	})
+}
+
+// 源任务结束后执行f，然后原样传递源任务的结果和错误
+func Finally[T any](t *TaskT[T], f func()) *TaskT[T] {
+	return ContinueWith(t, func(t *TaskT[T]) (T, error) {
+		f()
+		return t.GetResult()
+	})
+}
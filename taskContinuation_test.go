@@ -0,0 +1,132 @@
+package common
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThen(t *testing.T) {
+	c := NewTaskCompletionSourceT[int]()
+	finally := false
+	t1 := Then(&c.TaskT, func(r int) (string, error) {
+		return strconv.Itoa(r + 1), nil
+	})
+	t2 := Finally(t1, func() { finally = true })
+	if t2.IsDone() {
+		t.Errorf(`should not be done before source`)
+	}
+	c.SetResult(122)
+	r, err := t2.GetResult()
+	if err != nil || r != `123` {
+		t.Errorf(`got %v %v, expected 123`, r, err)
+	}
+	if !finally {
+		t.Errorf(`Finally not called`)
+	}
+}
+
+func TestThenError(t *testing.T) {
+	testErr := errors.New(`test error`)
+	called := false
+	t1 := Then(NewTaskWithResultT(func() (int, error) {
+		return 0, testErr
+	}), func(r int) (int, error) {
+		called = true
+		return r, nil
+	})
+	if _, err := t1.GetResult(); err != testErr {
+		t.Errorf(`error not propagated: %v`, err)
+	}
+	if called {
+		t.Errorf(`Then should not be called on error`)
+	}
+
+	t2 := Catch(t1, func(err error) (int, error) {
+		return 456, nil
+	})
+	if r, err := t2.GetResult(); err != nil || r != 456 {
+		t.Errorf(`Catch got %v %v, expected 456`, r, err)
+	}
+}
+
+func TestContinueWithCancel(t *testing.T) {
+	c := NewTaskCompletionSourceT[int]()
+	cancelled := false
+	c.cancelFunc = func() error {
+		cancelled = true
+		return nil
+	}
+	t1 := Then(&c.TaskT, func(r int) (int, error) { return r, nil })
+	if err := t1.Cancel(); err != nil || !cancelled {
+		t.Errorf(`Cancel not propagated to source`)
+	}
+}
+
+func TestContinueWithCancelBeforeStart(t *testing.T) {
+	c := NewTaskCompletionSourceT[int]()
+	called := false
+	t1 := Then(&c.TaskT, func(r int) (int, error) {
+		called = true
+		return r, nil
+	})
+	if err := t1.Cancel(); err != nil {
+		t.Errorf(`Cancel: %v`, err)
+	}
+	if !t1.IsCanceled() {
+		t.Errorf(`continuation should be canceled before f starts`)
+	}
+	c.SetResult(1)
+	time.Sleep(10 * time.Millisecond)
+	if called {
+		t.Errorf(`f should not run after Cancel`)
+	}
+
+	block := make(chan struct{})
+	defer close(block)
+	src := NewTaskWithResultT(func() (int, error) {
+		<-block
+		return 1, nil
+	})
+	t2 := Then(src, func(r int) (int, error) { return r, nil })
+	if err := t2.Cancel(); err == nil {
+		t.Errorf(`Cancel should return the source's error`)
+	}
+	if !t2.IsCanceled() {
+		t.Errorf(`continuation should be canceled`)
+	}
+}
+
+func TestContinueWithScheduler(t *testing.T) {
+	scheduler := NewTaskScheduler(1, 1, RejectPolicyReject)
+	block := make(chan struct{})
+	scheduler.Submit(func() { <-block })
+	SetDefaultTaskScheduler(scheduler)
+	defer SetDefaultTaskScheduler(nil)
+
+	c := NewTaskCompletionSourceT[int]()
+	called := false
+	t1 := Then(&c.TaskT, func(r int) (int, error) {
+		called = true
+		return r, nil
+	})
+	c.SetResult(1)
+	if scheduler.QueueLength() != 1 {
+		t.Errorf(`continuation should be queued on the default scheduler`)
+	}
+	// 还在排队，f没有开始执行
+	t1.Cancel()
+	close(block)
+	if _, err := t1.GetResult(); err != ErrTaskCanceled {
+		t.Errorf(`err = %v, expected ErrTaskCanceled`, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if called {
+		t.Errorf(`f should not run after Cancel`)
+	}
+
+	if _, err := Then(&c.TaskT, func(r int) (int, error) { return r, nil }).GetResult(); err != nil {
+		t.Errorf(`err = %v`, err)
+	}
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+ *	按key合并并发的相同请求，同一时间每个key只有一个任务在执行(singleflight)
+ */
+
+type TaskDeduper[K comparable, T any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[K]*dedupEntry[T]
+}
+
+type dedupEntry[T any] struct {
+	task    *TaskT[T]
+	callers int       // 通过DoCtx等待的调用者数量
+	pinned  bool      // 通过Do获取过，任务不会因为调用者离开而取消
+	expires time.Time // 任务成功后结果缓存到何时
+}
+
+func NewTaskDeduper[K comparable, T any]() *TaskDeduper[K, T] {
+	return &TaskDeduper[K, T]{
+		entries: make(map[K]*dedupEntry[T]),
+	}
+}
+
+// 任务成功后把结果缓存ttl时间，期间相同key直接返回已完成的任务，过期后自动清理。默认任务结束后马上遗忘
+func (me *TaskDeduper[K, T]) WithTTL(ttl time.Duration) *TaskDeduper[K, T] {
+	me.ttl = ttl
+	return me
+}
+
+// 调用前须持有me.mu。新建的任务还没有提交，start不为nil时调用者须在释放me.mu之后调用start，
+// 避免调度器阻塞或者在调用者的go proc里执行fn时持有me.mu
+func (me *TaskDeduper[K, T]) getOrStart(key K, fn func(ctx context.Context) (T, error)) (entry *dedupEntry[T], start func()) {
+	if entry, ok := me.entries[key]; ok {
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			return entry, nil
+		}
+		delete(me.entries, key)
+	}
+
+	task, submit := newTaskCtxT(context.Background(), fn)
+	entry = &dedupEntry[T]{
+		task: task,
+	}
+	me.entries[key] = entry
+	return entry, func() {
+		me.watch(key, entry)
+		submit(DefaultTaskScheduler())
+	}
+}
+
+// 任务结束时清理entry，或者缓存到ttl之后再清理。任务已经结束时回调会马上执行，所以不能持有me.mu调用
+func (me *TaskDeduper[K, T]) watch(key K, entry *dedupEntry[T]) {
+	entry.task.onDone(func() {
+		me.mu.Lock()
+		defer me.mu.Unlock()
+		if me.entries[key] != entry {
+			return
+		}
+		if me.ttl > 0 && entry.task.err == nil {
+			entry.expires = time.Now().Add(me.ttl)
+			time.AfterFunc(me.ttl, func() { me.evict(key, entry) })
+		} else {
+			delete(me.entries, key)
+		}
+	})
+}
+
+func (me *TaskDeduper[K, T]) evict(key K, entry *dedupEntry[T]) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.entries[key] == entry {
+		delete(me.entries, key)
+	}
+}
+
+// 返回key对应的正在执行(或缓存中)的任务，没有则用fn启动一个。返回的任务会一直执行到结束
+func (me *TaskDeduper[K, T]) Do(key K, fn func(ctx context.Context) (T, error)) *TaskT[T] {
+	me.mu.Lock()
+	entry, start := me.getOrStart(key, fn)
+	entry.pinned = true
+	me.mu.Unlock()
+	if start != nil {
+		start()
+	}
+	return entry.task
+}
+
+// 与Do相同，但返回只属于当前调用者的任务：ctx结束或Cancel()时该任务以ErrTaskCanceled结束，
+// 当所有调用者都离开(且没有通过Do获取过)时才取消共享的任务
+func (me *TaskDeduper[K, T]) DoCtx(ctx context.Context, key K, fn func(ctx context.Context) (T, error)) *TaskT[T] {
+	me.mu.Lock()
+	entry, start := me.getOrStart(key, fn)
+	shared := entry.task
+	done := shared.IsDone()
+	if !done {
+		entry.callers++
+	}
+	me.mu.Unlock()
+	if start != nil {
+		start()
+	}
+	if done {
+		return shared
+	}
+
+	caller := NewCancelCtx(ctx)
+	result := NewTaskCompletionSourceT[T]()
+	result.cancelFunc = func() error {
+		caller.Cancel()
+		return nil
+	}
+	go func() {
+		select {
+		case <-shared.Done():
+			result.complete(shared.GetResult())
+		case <-caller.Done():
+			result.SetCanceled()
+			me.leave(key, entry)
+		}
+		caller.Cancel()
+	}()
+	return &result.TaskT
+}
+
+func (me *TaskDeduper[K, T]) leave(key K, entry *dedupEntry[T]) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	entry.callers--
+	if entry.callers == 0 && !entry.pinned && !entry.task.IsDone() {
+		if me.entries[key] == entry {
+			delete(me.entries, key)
+		}
+		entry.task.Cancel()
+	}
+}
+
+// 遗忘key，之后的Do/DoCtx会启动新的任务。不影响正在执行的任务
+func (me *TaskDeduper[K, T]) Forget(key K) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.entries, key)
+}
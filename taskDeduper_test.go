@@ -0,0 +1,151 @@
+package common
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaskDeduper(t *testing.T) {
+	var count atomic.Int32
+	fn := func(ctx context.Context) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		return int(count.Add(1)), nil
+	}
+
+	d := NewTaskDeduper[string, int]()
+	t1 := d.Do(`a`, fn)
+	t2 := d.Do(`a`, fn)
+	t3 := d.Do(`b`, fn)
+	if t1 != t2 || t1 == t3 {
+		t.Errorf(`same key should share the task`)
+	}
+	WaitAllT(context.Background(), t1, t3)
+	time.Sleep(10 * time.Millisecond)
+	if t4 := d.Do(`a`, fn); t4 == t1 {
+		t.Errorf(`key should be forgotten after completion`)
+	}
+
+	d = NewTaskDeduper[string, int]().WithTTL(time.Hour)
+	t1 = d.Do(`a`, fn)
+	t1.Wait()
+	time.Sleep(10 * time.Millisecond)
+	if t2 := d.Do(`a`, fn); t2 != t1 {
+		t.Errorf(`result should be cached`)
+	}
+
+	// 过期的entry即使没有再次请求也会被清理
+	d = NewTaskDeduper[string, int]().WithTTL(10 * time.Millisecond)
+	for _, key := range []string{`a`, `b`, `c`} {
+		d.Do(key, fn).Wait()
+	}
+	time.Sleep(50 * time.Millisecond)
+	d.mu.Lock()
+	if n := len(d.entries); n != 0 {
+		t.Errorf(`%d expired entries kept`, n)
+	}
+	d.mu.Unlock()
+}
+
+func TestTaskDeduperCallerCancel(t *testing.T) {
+	d := NewTaskDeduper[string, int]()
+	fn := func(ctx context.Context) (int, error) {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+			return 123, nil
+		}
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	c1 := d.DoCtx(ctx1, `a`, fn)
+	c2 := d.DoCtx(context.Background(), `a`, fn)
+	cancel1()
+	if _, err := c1.GetResult(); err != ErrTaskCanceled {
+		t.Errorf(`caller 1 err = %v, expected ErrTaskCanceled`, err)
+	}
+	if r, err := c2.GetResult(); r != 123 || err != nil {
+		t.Errorf(`caller 2 got %v %v, expected 123`, r, err)
+	}
+
+	c3 := d.DoCtx(context.Background(), `b`, fn)
+	shared := d.Do(`b`, fn)
+	c3.Cancel()
+	if r, err := shared.GetResult(); r != 123 || err != nil {
+		t.Errorf(`pinned shared task got %v %v, expected 123`, r, err)
+	}
+
+	c4 := d.DoCtx(context.Background(), `c`, fn)
+	d.mu.Lock()
+	shared = d.entries[`c`].task
+	d.mu.Unlock()
+	c4.Cancel()
+	if _, err := shared.GetResult(); err != ErrTaskCanceled {
+		t.Errorf(`shared task err = %v, expected ErrTaskCanceled`, err)
+	}
+}
+
+func TestTaskDeduperRejected(t *testing.T) {
+	scheduler := NewTaskScheduler(1, 0, RejectPolicyReject)
+	block := make(chan struct{})
+	scheduler.Submit(func() { <-block })
+	SetDefaultTaskScheduler(scheduler)
+	defer func() {
+		SetDefaultTaskScheduler(nil)
+		close(block)
+	}()
+
+	fn := func(ctx context.Context) (int, error) { return 1, nil }
+	d := NewTaskDeduper[string, int]()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := d.Do(`a`, fn).GetResult(); err != TaskRejectedError {
+			t.Errorf(`Do err = %v, expected TaskRejectedError`, err)
+		}
+		if _, err := d.DoCtx(context.Background(), `b`, fn).GetResult(); err != TaskRejectedError {
+			t.Errorf(`DoCtx err = %v, expected TaskRejectedError`, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf(`Do deadlocked on a rejected task`)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.entries) != 0 {
+		t.Errorf(`rejected tasks should not be kept`)
+	}
+}
+
+func TestTaskDeduperCallerRuns(t *testing.T) {
+	scheduler := NewTaskScheduler(1, 0, RejectPolicyCallerRuns)
+	block := make(chan struct{})
+	scheduler.Submit(func() { <-block })
+	SetDefaultTaskScheduler(scheduler)
+	defer func() {
+		SetDefaultTaskScheduler(nil)
+		close(block)
+	}()
+
+	d := NewTaskDeduper[string, int]()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// fn在调用者的go proc里执行，再次调用同一个deduper不能死锁
+		r, err := d.Do(`a`, func(ctx context.Context) (int, error) {
+			return d.Do(`b`, func(ctx context.Context) (int, error) { return 2, nil }).GetResult()
+		}).GetResult()
+		if r != 2 || err != nil {
+			t.Errorf(`got %v %v, expected 2`, r, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf(`Do deadlocked with RejectPolicyCallerRuns`)
+	}
+}
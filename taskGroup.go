@@ -0,0 +1,117 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+ *	任务组：一组共享同一个CancelCtx的子任务，Wait()等待全部结束
+ */
+
+type TaskGroup struct {
+	ctx        *CancelCtx
+	collectAll bool
+	scheduler  *TaskScheduler
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	errs     []error
+	canceled bool
+}
+
+// 创建任务组，ctx结束或收到程序退出信号时取消所有子任务。
+// 默认fail-fast：任一子任务失败即取消其它子任务
+func NewTaskGroup(ctx context.Context) *TaskGroup {
+	result := &TaskGroup{
+		ctx:       NewCancelCtx(ctx),
+		scheduler: DefaultTaskScheduler(),
+	}
+	go func() {
+		select {
+		case <-ProgramDone():
+			result.ctx.Cancel()
+		case <-result.ctx.Done():
+		}
+	}()
+	return result
+}
+
+// 子任务失败时不取消其它子任务，Wait()返回所有错误的合并
+func (me *TaskGroup) CollectAll() *TaskGroup {
+	me.collectAll = true
+	return me
+}
+
+// 最多同时执行n个子任务，超出时Go/GoT会阻塞直到有子任务结束；
+// 阻塞期间任务组被取消则马上返回，子任务以ErrTaskCanceled结束。必须在启动子任务之前调用
+func (me *TaskGroup) WithLimit(n int) *TaskGroup {
+	me.scheduler = NewTaskScheduler(n, 0, RejectPolicyBlock)
+	return me
+}
+
+// 子任务共享的context
+func (me *TaskGroup) Context() context.Context {
+	return me.ctx
+}
+
+// 取消所有子任务
+func (me *TaskGroup) Cancel() {
+	me.ctx.Cancel()
+}
+
+func (me *TaskGroup) Go(fun func(ctx context.Context) error) *Task {
+	return GoT(me, func(ctx context.Context) (any, error) {
+		return nil, fun(ctx)
+	})
+}
+
+// 在任务组中启动子任务
+func GoT[T any](g *TaskGroup, fun func(ctx context.Context) (T, error)) *TaskT[T] {
+	g.wg.Add(1)
+	result := NewTaskCtxTOn(g.scheduler, g.ctx, fun)
+	result.onDone(func() {
+		g.record(result.err)
+		g.wg.Done()
+	})
+	return result
+}
+
+func (me *TaskGroup) record(err error) {
+	if err == nil {
+		return
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if errors.Is(err, ErrTaskCanceled) {
+		me.canceled = true
+		return
+	}
+	me.errs = append(me.errs, err)
+	if !me.collectAll {
+		me.ctx.Cancel()
+	}
+}
+
+// 等待所有子任务结束。fail-fast模式返回第一个错误，CollectAll模式返回所有错误的合并；
+// 没有失败但有子任务被取消时，返回ProgramExitingError(程序退出)或ErrTaskCanceled
+func (me *TaskGroup) Wait() error {
+	me.wg.Wait()
+	me.ctx.Cancel()
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	switch {
+	case len(me.errs) > 0 && !me.collectAll:
+		return me.errs[0]
+	case len(me.errs) > 0:
+		return errors.Join(me.errs...)
+	case me.canceled && IsProgramDone():
+		return ProgramExitingError
+	case me.canceled:
+		return ErrTaskCanceled
+	}
+	return nil
+}
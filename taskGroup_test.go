@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTaskGroupFailFast(t *testing.T) {
+	testErr := errors.New(`test error`)
+	g := NewTaskGroup(context.Background())
+	slow := g.Go(func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	g.Go(func(ctx context.Context) error {
+		return testErr
+	})
+	start := time.Now()
+	if err := g.Wait(); err != testErr {
+		t.Errorf(`err = %v, expected test error`, err)
+	}
+	if time.Since(start) > 500*time.Millisecond || !slow.IsCanceled() {
+		t.Errorf(`sibling should be canceled`)
+	}
+}
+
+func TestTaskGroupCollectAll(t *testing.T) {
+	err1 := errors.New(`error 1`)
+	err2 := errors.New(`error 2`)
+	g := NewTaskGroup(context.Background()).CollectAll()
+	g.Go(func(ctx context.Context) error { return err1 })
+	ok := GoT(g, func(ctx context.Context) (int, error) {
+		time.Sleep(50 * time.Millisecond)
+		return 123, nil
+	})
+	g.Go(func(ctx context.Context) error { return err2 })
+	err := g.Wait()
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Errorf(`err = %v, expected both errors`, err)
+	}
+	if r, err := ok.GetResult(); r != 123 || err != nil {
+		t.Errorf(`got %v %v, expected 123`, r, err)
+	}
+}
+
+func TestTaskGroupLimit(t *testing.T) {
+	var tracker concurrencyTracker
+	g := NewTaskGroup(context.Background()).WithLimit(3)
+	for i := 0; i < 10; i++ {
+		g.Go(func(ctx context.Context) error {
+			defer tracker.enter()()
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Errorf(`err = %v`, err)
+	}
+	if tracker.max.Load() != 3 {
+		t.Errorf(`max running = %d, expected 3`, tracker.max.Load())
+	}
+}
+
+func TestTaskGroupLimitCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := NewTaskGroup(ctx).WithLimit(1)
+	release := make(chan struct{})
+	g.Go(func(ctx context.Context) error {
+		<-release // 不理会ctx，一直占用名额
+		return nil
+	})
+
+	done := make(chan *Task)
+	go func() {
+		done <- g.Go(func(ctx context.Context) error { return nil })
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case task := <-done:
+		if !task.IsCanceled() {
+			t.Errorf(`blocked child should end with ErrTaskCanceled`)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf(`Go still blocked after the group was canceled`)
+	}
+	close(release)
+	if err := g.Wait(); err != ErrTaskCanceled {
+		t.Errorf(`err = %v, expected ErrTaskCanceled`, err)
+	}
+}
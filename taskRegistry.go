@@ -0,0 +1,186 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+ *	任务登记：记录未结束的任务，用于排查程序卡住的问题
+ */
+
+type TaskStatus int32
+
+const (
+	TaskStatusPending   TaskStatus = iota // 等待调度器执行，或等待TaskCompletionSource设置结果
+	TaskStatusRunning                     // 正在执行
+	TaskStatusSucceeded                   // 成功结束
+	TaskStatusFailed                      // 失败结束
+	TaskStatusCanceled                    // 以ErrTaskCanceled结束
+)
+
+var taskStatusNames = []string{`pending`, `running`, `succeeded`, `failed`, `canceled`}
+
+func (me TaskStatus) String() string {
+	if me >= 0 && int(me) < len(taskStatusNames) {
+		return taskStatusNames[me]
+	}
+	return fmt.Sprintf(`TaskStatus(%d)`, int32(me))
+}
+
+func (me TaskStatus) MarshalText() ([]byte, error) {
+	return []byte(me.String()), nil
+}
+
+// 未结束任务的信息
+type TaskInfo struct {
+	Id        uint64     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Status    TaskStatus `json:"status"`
+	StartTime time.Time  `json:"startTime"`
+	CallSite  string     `json:"callSite"`
+}
+
+type registeredTask interface {
+	info() TaskInfo
+}
+
+var (
+	taskRegistryEnabled atomic.Bool
+	taskRegistryMu      sync.Mutex
+	taskRegistry        = map[uint64]registeredTask{}
+	lastTaskId          atomic.Uint64
+	packageDir          string
+)
+
+func init() {
+	_, file, _, _ := runtime.Caller(0)
+	packageDir = filepath.Dir(file)
+}
+
+// 开启/关闭任务登记。开启后创建的任务才会被登记，登记需要记录调用位置，会有一定开销
+func EnableTaskRegistry(enable bool) {
+	taskRegistryEnabled.Store(enable)
+}
+
+// 给任务命名，便于在RunningTasks/DumpTasks中识别
+func (me *TaskT[T]) Named(name string) *TaskT[T] {
+	me.mu.Lock()
+	me.name = name
+	me.mu.Unlock()
+	return me
+}
+
+func (me *TaskT[T]) Name() string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.name
+}
+
+func (me *TaskT[T]) Status() TaskStatus {
+	select {
+	case <-me.Done():
+		switch {
+		case me.err == nil:
+			return TaskStatusSucceeded
+		case me.IsCanceled():
+			return TaskStatusCanceled
+		default:
+			return TaskStatusFailed
+		}
+	default:
+		return TaskStatus(me.status.Load())
+	}
+}
+
+func (me *TaskT[T]) info() TaskInfo {
+	return TaskInfo{
+		Id:        me.id,
+		Name:      me.Name(),
+		Status:    me.Status(),
+		StartTime: me.startTime,
+		CallSite:  me.callSite,
+	}
+}
+
+func (me *TaskT[T]) register() {
+	if !taskRegistryEnabled.Load() {
+		return
+	}
+	me.id = lastTaskId.Add(1)
+	me.startTime = time.Now()
+	me.callSite = taskCallSite()
+
+	taskRegistryMu.Lock()
+	taskRegistry[me.id] = me
+	taskRegistryMu.Unlock()
+}
+
+func (me *TaskT[T]) unregister() {
+	if me.id == 0 {
+		return
+	}
+	taskRegistryMu.Lock()
+	delete(taskRegistry, me.id)
+	taskRegistryMu.Unlock()
+}
+
+// 第一个不在本库(测试文件除外)中的调用位置
+func taskCallSite() string {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != packageDir || strings.HasSuffix(frame.File, `_test.go`) {
+			return fmt.Sprintf(`%s:%d %s`, frame.File, frame.Line, frame.Function)
+		}
+		if !more {
+			return ``
+		}
+	}
+}
+
+// 返回所有未结束的已登记任务，按创建顺序排列
+func RunningTasks() []TaskInfo {
+	taskRegistryMu.Lock()
+	tasks := make([]registeredTask, 0, len(taskRegistry))
+	for _, t := range taskRegistry {
+		tasks = append(tasks, t)
+	}
+	taskRegistryMu.Unlock()
+
+	result := make([]TaskInfo, len(tasks))
+	for i, t := range tasks {
+		result[i] = t.info()
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+// 以文本表格输出所有未结束的已登记任务
+func DumpTasks(w io.Writer) error {
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tNAME\tSTATUS\tAGE\tCALL SITE\n")
+	for _, t := range RunningTasks() {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", t.Id, t.Name, t.Status, now.Sub(t.StartTime).Round(time.Millisecond), t.CallSite)
+	}
+	return tw.Flush()
+}
+
+// 以JSON数组输出所有未结束的已登记任务
+func DumpTasksJson() ([]byte, error) {
+	return json.Marshal(RunningTasks())
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func findTaskInfo(name string) *TaskInfo {
+	for _, info := range RunningTasks() {
+		if info.Name == name {
+			return &info
+		}
+	}
+	return nil
+}
+
+func TestTaskRegistry(t *testing.T) {
+	EnableTaskRegistry(true)
+	defer EnableTaskRegistry(false)
+
+	c := NewTaskCompletionSourceT[int]()
+	c.Named(`test-pending`)
+	started := NewEvent()
+	task := NewTaskWithResultT(func() (int, error) {
+		started.Set()
+		c.Wait()
+		return 0, nil
+	}).Named(`test-running`)
+	started.Wait()
+
+	info := findTaskInfo(`test-pending`)
+	if info == nil || info.Status != TaskStatusPending || !strings.Contains(info.CallSite, `taskRegistry_test.go`) {
+		t.Errorf(`test-pending info = %+v`, info)
+	}
+	info = findTaskInfo(`test-running`)
+	if info == nil || info.Status != TaskStatusRunning {
+		t.Errorf(`test-running info = %+v`, info)
+	}
+
+	buf := &bytes.Buffer{}
+	DumpTasks(buf)
+	if !strings.Contains(buf.String(), `test-running`) {
+		t.Errorf(`DumpTasks = %s`, buf.String())
+	}
+	bts, err := DumpTasksJson()
+	var infos []map[string]interface{}
+	if err != nil || json.Unmarshal(bts, &infos) != nil || !strings.Contains(string(bts), `"status":"running"`) {
+		t.Errorf(`DumpTasksJson = %s, %v`, bts, err)
+	}
+
+	c.SetResult(1)
+	task.Wait()
+	if findTaskInfo(`test-pending`) != nil || findTaskInfo(`test-running`) != nil {
+		t.Errorf(`finished tasks should be unregistered`)
+	}
+	if task.Status() != TaskStatusSucceeded {
+		t.Errorf(`status = %v, expected succeeded`, task.Status())
+	}
+}
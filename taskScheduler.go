@@ -0,0 +1,168 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ *	任务调度器：限制同时执行的任务数量，超出的任务进入有界队列等待
+ */
+
+// 队列满时的处理策略
+type RejectPolicy int
+
+const (
+	RejectPolicyReject     RejectPolicy = iota // 拒绝，Submit返回TaskRejectedError
+	RejectPolicyBlock                          // 阻塞调用者直到队列有空位
+	RejectPolicyCallerRuns                     // 在调用者的go proc里直接执行
+)
+
+var (
+	TaskRejectedError = errors.New(`task rejected by scheduler`)
+)
+
+type TaskScheduler struct {
+	maxConcurrency int
+	queueSize      int
+	policy         RejectPolicy
+
+	mu      sync.Mutex
+	active  int
+	queue   []func()
+	notFull chan struct{} // 有任务出队或worker退出时close并替换，唤醒阻塞的Submit
+
+	rejected atomic.Int64
+}
+
+var defaultTaskScheduler atomic.Pointer[TaskScheduler]
+
+// maxConcurrency: 最多同时执行的任务数，必须>0
+// queueSize: 等待队列长度，0表示不排队
+func NewTaskScheduler(maxConcurrency, queueSize int, policy RejectPolicy) *TaskScheduler {
+	if maxConcurrency <= 0 {
+		panic(`maxConcurrency must be > 0`)
+	}
+	return &TaskScheduler{
+		maxConcurrency: maxConcurrency,
+		queueSize:      queueSize,
+		policy:         policy,
+		notFull:        make(chan struct{}),
+	}
+}
+
+// 设置NewTask等构造函数默认使用的调度器，nil表示每个任务直接启动新的go proc(默认)
+func SetDefaultTaskScheduler(scheduler *TaskScheduler) {
+	defaultTaskScheduler.Store(scheduler)
+}
+
+func DefaultTaskScheduler() *TaskScheduler {
+	return defaultTaskScheduler.Load()
+}
+
+// 提交f执行。nil调度器直接启动新的go proc。
+// 队列满时按策略处理：拒绝时返回TaskRejectedError，阻塞期间收到程序退出信号返回ProgramExitingError
+func (me *TaskScheduler) Submit(f func()) error {
+	return me.submit(nil, f)
+}
+
+// 与Submit相同，但阻塞期间done被关闭时返回ErrTaskCanceled
+func (me *TaskScheduler) submit(done <-chan struct{}, f func()) error {
+	if me == nil {
+		go f()
+		return nil
+	}
+
+	for {
+		me.mu.Lock()
+		if me.active < me.maxConcurrency {
+			me.active++
+			me.mu.Unlock()
+			go me.worker(f)
+			return nil
+		}
+		if len(me.queue) < me.queueSize {
+			me.queue = append(me.queue, f)
+			me.mu.Unlock()
+			return nil
+		}
+		notFull := me.notFull
+		me.mu.Unlock()
+
+		switch me.policy {
+		case RejectPolicyCallerRuns:
+			f()
+			return nil
+		case RejectPolicyBlock:
+			select {
+			case <-notFull:
+			case <-done:
+				return ErrTaskCanceled
+			case <-ProgramDone():
+				return ProgramExitingError
+			}
+		default:
+			me.rejected.Add(1)
+			return TaskRejectedError
+		}
+	}
+}
+
+func (me *TaskScheduler) worker(f func()) {
+	for f != nil {
+		f()
+
+		me.mu.Lock()
+		if len(me.queue) > 0 {
+			f = me.queue[0]
+			me.queue[0] = nil
+			me.queue = me.queue[1:]
+		} else {
+			f = nil
+			me.active--
+		}
+		me.signalNotFull()
+		me.mu.Unlock()
+	}
+}
+
+// 调用前须持有me.mu
+func (me *TaskScheduler) signalNotFull() {
+	close(me.notFull)
+	me.notFull = make(chan struct{})
+}
+
+// 正在排队等待执行的任务数
+func (me *TaskScheduler) QueueLength() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return len(me.queue)
+}
+
+// 正在执行任务的worker数
+func (me *TaskScheduler) ActiveWorkers() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.active
+}
+
+// 累计被拒绝的任务数
+func (me *TaskScheduler) RejectedCount() int64 {
+	return me.rejected.Load()
+}
+
+// 把f提交到调度器，如果被拒绝则以该错误结束任务
+func (me *TaskT[T]) submitTo(scheduler *TaskScheduler, f func()) error {
+	return me.submitCtxTo(scheduler, nil, f)
+}
+
+// 与submitTo相同，但阻塞等待调度器期间done被关闭时以ErrTaskCanceled结束任务
+func (me *TaskT[T]) submitCtxTo(scheduler *TaskScheduler, done <-chan struct{}, f func()) error {
+	err := scheduler.submit(done, f)
+	if err != nil {
+		var r T
+		me.complete(r, err)
+	}
+	return err
+}
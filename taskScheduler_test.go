@@ -0,0 +1,100 @@
+package common
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 统计同时执行的数量，记录最大值
+type concurrencyTracker struct {
+	running atomic.Int32
+	max     atomic.Int32
+}
+
+// 开始执行时调用，返回的函数在执行结束时调用
+func (me *concurrencyTracker) enter() func() {
+	n := me.running.Add(1)
+	for {
+		m := me.max.Load()
+		if n <= m || me.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	return func() { me.running.Add(-1) }
+}
+
+func TestTaskSchedulerConcurrency(t *testing.T) {
+	s := NewTaskScheduler(2, 10, RejectPolicyReject)
+	var tracker concurrencyTracker
+	tasks := make([]*Task, 0, 6)
+	for i := 0; i < 6; i++ {
+		tasks = append(tasks, NewTaskOn(s, func() error {
+			defer tracker.enter()()
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}))
+	}
+	if s.ActiveWorkers() != 2 || s.QueueLength() != 4 {
+		t.Errorf(`active = %d, queue = %d, expected 2, 4`, s.ActiveWorkers(), s.QueueLength())
+	}
+	WaitAllTasks(tasks...)
+	if tracker.max.Load() != 2 {
+		t.Errorf(`max running = %d, expected 2`, tracker.max.Load())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if s.ActiveWorkers() != 0 || s.QueueLength() != 0 {
+		t.Errorf(`active = %d, queue = %d, expected 0, 0`, s.ActiveWorkers(), s.QueueLength())
+	}
+}
+
+func TestTaskSchedulerReject(t *testing.T) {
+	s := NewTaskScheduler(1, 0, RejectPolicyReject)
+	c := NewTaskCompletionSource()
+	t1 := NewTaskOn(s, func() error {
+		c.Wait()
+		return nil
+	})
+	t2 := NewTaskOn(s, func() error { return nil })
+	if _, err := t2.GetResult(); err != TaskRejectedError {
+		t.Errorf(`err = %v, expected TaskRejectedError`, err)
+	}
+	if s.RejectedCount() != 1 {
+		t.Errorf(`rejected = %d, expected 1`, s.RejectedCount())
+	}
+	c.SetResult(nil)
+	t1.Wait()
+}
+
+func TestTaskSchedulerCallerRuns(t *testing.T) {
+	s := NewTaskScheduler(1, 0, RejectPolicyCallerRuns)
+	c := NewTaskCompletionSource()
+	t1 := NewTaskOn(s, func() error {
+		c.Wait()
+		return nil
+	})
+	ran := false
+	NewTaskOn(s, func() error {
+		ran = true
+		return nil
+	})
+	if !ran {
+		t.Errorf(`should run in caller`)
+	}
+	c.SetResult(nil)
+	t1.Wait()
+}
+
+func TestTaskSchedulerBlock(t *testing.T) {
+	s := NewTaskScheduler(1, 0, RejectPolicyBlock)
+	start := time.Now()
+	t1 := NewTaskOn(s, func() error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	t2 := NewTaskOn(s, func() error { return nil })
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf(`Submit should block`)
+	}
+	WaitAllTasks(t1, t2)
+}
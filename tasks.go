@@ -2,7 +2,9 @@ package common
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"math"
+	"math/rand"
 	"os"
 	"os/signal"
 	"reflect"
@@ -62,26 +64,42 @@ func IsProgramDone() bool {
 // TimerCallback 回调函数定义
 type TimerCallback func()
 
+// TimerErrorCallback 返回error的回调函数，返回非nil表示执行失败
+type TimerErrorCallback func() error
+
 type GetIntervalFunc func() time.Duration
 
-type SetIntervalTask struct {
-	interval    time.Duration
+// SetIntervalTask 和 SetIntervalFuncTask 共用的选项
+type intervalOptions struct {
 	callback    TimerCallback
+	errCallback TimerErrorCallback
 	ctx         context.Context
 	cleanupFunc func()
 
 	skipFirstInterval  bool
 	runInCurrentGoProc bool
+	keepPhase          bool // 和time.Ticker一样，回调执行时间过长时保持原来的节奏
+	overlapPolicy      OverlapPolicy
+	maxConcurrent      int
+
+	randomRange [2]time.Duration // 不为0时，间隔在[min, max)内均匀随机，代替原来的间隔
+	jitter      float64          // 间隔随机浮动的比例，0 <= jitter < 1
+	rand        *rand.Rand
+	clock       Clock
+
+	errorHandler   func(err error)
+	maxFailures    int
+	failureBackoff Backoff
+}
+
+type SetIntervalTask struct {
+	intervalOptions
+	interval time.Duration
 }
 
 type SetIntervalFuncTask struct {
+	intervalOptions
 	intervalFunc GetIntervalFunc
-	callback     TimerCallback
-	ctx          context.Context
-	cleanupFunc  func()
-
-	skipFirstInterval  bool
-	runInCurrentGoProc bool
 }
 
 // Run/RunInCurrentGoProc的时候，先马上*在当前go proc*执行一次，然后定时执行。
@@ -95,6 +113,122 @@ func (me *SetIntervalFuncTask) SkipFirstInterval() *SetIntervalFuncTask {
 	return me
 }
 
+// 设置回调执行时间超过间隔时的处理策略，默认OverlapInline
+func (me *SetIntervalTask) WithOverlap(policy OverlapPolicy) *SetIntervalTask {
+	me.overlapPolicy = policy
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithOverlap(policy OverlapPolicy) *SetIntervalFuncTask {
+	me.overlapPolicy = policy
+	return me
+}
+
+// 使用OverlapConcurrent策略，最多同时执行n个回调
+func (me *SetIntervalTask) WithMaxConcurrent(n int) *SetIntervalTask {
+	if n <= 0 {
+		panic(`n must be > 0`)
+	}
+	me.overlapPolicy = OverlapConcurrent
+	me.maxConcurrent = n
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithMaxConcurrent(n int) *SetIntervalFuncTask {
+	if n <= 0 {
+		panic(`n must be > 0`)
+	}
+	me.overlapPolicy = OverlapConcurrent
+	me.maxConcurrent = n
+	return me
+}
+
+// 每次的间隔在[min, max)内均匀随机，代替原来的间隔，避免大量定时器同时执行
+func (me *SetIntervalTask) WithRandomRange(min, max time.Duration) *SetIntervalTask {
+	me.setRandomRange(min, max)
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithRandomRange(min, max time.Duration) *SetIntervalFuncTask {
+	me.setRandomRange(min, max)
+	return me
+}
+
+// 每次的间隔随机浮动±percent，例如0.1表示在90%到110%之间
+func (me *SetIntervalTask) WithJitter(percent float64) *SetIntervalTask {
+	me.setJitter(percent)
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithJitter(percent float64) *SetIntervalFuncTask {
+	me.setJitter(percent)
+	return me
+}
+
+// 使用指定的随机数生成器，测试时可以传入固定种子。r只会在定时器go proc里使用
+func (me *SetIntervalTask) WithRand(r *rand.Rand) *SetIntervalTask {
+	me.rand = r
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithRand(r *rand.Rand) *SetIntervalFuncTask {
+	me.rand = r
+	return me
+}
+
+// 使用指定的时钟，默认DefaultClock()
+func (me *SetIntervalTask) WithClock(clock Clock) *SetIntervalTask {
+	me.clock = clock
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithClock(clock Clock) *SetIntervalFuncTask {
+	me.clock = clock
+	return me
+}
+
+// 回调失败(返回error或者panic)时调用f。没有设置时panic交给全局panic回调，错误交给全局错误回调，见SetPanicHandler和SetErrorHandler
+func (me *SetIntervalTask) OnError(f func(err error)) *SetIntervalTask {
+	me.errorHandler = f
+	return me
+}
+
+func (me *SetIntervalFuncTask) OnError(f func(err error)) *SetIntervalFuncTask {
+	me.errorHandler = f
+	return me
+}
+
+// 连续失败n次后停止定时器，IntervalHandle.Err()返回最后一次的错误
+func (me *SetIntervalTask) WithMaxFailures(n int) *SetIntervalTask {
+	if n <= 0 {
+		panic(`n must be > 0`)
+	}
+	me.maxFailures = n
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithMaxFailures(n int) *SetIntervalFuncTask {
+	if n <= 0 {
+		panic(`n must be > 0`)
+	}
+	me.maxFailures = n
+	return me
+}
+
+// 失败后按backoff计算下一次执行的间隔(attempt为连续失败的次数)，成功后恢复原来的间隔。
+// 在新的go proc里执行回调时(OverlapSkip等)，失败结果在下一次到期时才生效
+func (me *SetIntervalTask) WithFailureBackoff(backoff Backoff) *SetIntervalTask {
+	me.failureBackoff = backoff
+	return me
+}
+
+func (me *SetIntervalFuncTask) WithFailureBackoff(backoff Backoff) *SetIntervalFuncTask {
+	me.failureBackoff = backoff
+	return me
+}
+
+// 在当前go proc执行定时器，直到ctx结束、连续失败次数达到上限或程序退出才返回。
+// 这种方式拿不到IntervalHandle，需要在其他go proc停止时使用WithContext
 func (me *SetIntervalTask) RunInCurrentGoProc() {
 	me.runInCurrentGoProc = true
 	me.Run()
@@ -112,81 +246,150 @@ func (me *SetIntervalFuncTask) WithContext(ctx context.Context, cleanupFunc func
 	return me
 }
 
-func (me *SetIntervalTask) Run() {
-	if me.skipFirstInterval {
-		me.callback()
+// 启动定时器，返回用于控制定时器的句柄。
+// 与time.Ticker一样，回调执行时间过长时错过的执行合并成一次马上执行，之后按原来的节奏执行
+func (me *SetIntervalTask) Run() *IntervalHandle {
+	me.keepPhase = true
+	interval := me.interval
+	return me.run(func() time.Duration { return interval })
+}
+
+// 启动定时器，返回用于控制定时器的句柄。IntervalHandle.Reset之后不再调用intervalFunc
+func (me *SetIntervalFuncTask) Run() *IntervalHandle {
+	return me.run(me.intervalFunc)
+}
+
+// 见SetIntervalTask.RunInCurrentGoProc
+func (me *SetIntervalFuncTask) RunInCurrentGoProc() {
+	me.runInCurrentGoProc = true
+	me.Run()
+}
+
+func (me *intervalOptions) setRandomRange(min, max time.Duration) {
+	if min <= 0 || max < min {
+		panic(`range must be 0 < min <= max`)
 	}
+	me.randomRange = [2]time.Duration{min, max}
+}
 
-	fun := func() {
-		timer := time.NewTicker(me.interval)
-		defer func() {
-			timer.Stop()
-			if me.cleanupFunc != nil {
-				me.cleanupFunc()
-			}
-		}()
+func (me *intervalOptions) setJitter(percent float64) {
+	if percent < 0 || percent >= 1 {
+		panic(`percent must be in [0, 1)`)
+	}
+	me.jitter = percent
+}
 
-		for {
-			select {
-			case <-timer.C:
-				me.callback()
-			case <-ProgramDone():
-				return
-			case <-me.ctx.Done():
-				return
-			}
-		}
+func (me *intervalOptions) int63n(n int64) int64 {
+	if me.rand != nil {
+		return me.rand.Int63n(n)
 	}
+	return rand.Int63n(n)
+}
 
-	if !me.runInCurrentGoProc {
-		go fun()
-	} else {
-		fun()
+func (me *intervalOptions) randomIn(_range [2]time.Duration) time.Duration {
+	if _range[1] <= _range[0] {
+		return _range[0]
+	}
+	return _range[0] + time.Duration(me.int63n(int64(_range[1]-_range[0])))
+}
+
+// 在interval的基础上加上随机浮动
+func (me *intervalOptions) applyJitter(interval time.Duration) time.Duration {
+	delta := time.Duration(float64(interval) * me.jitter)
+	if delta <= 0 {
+		return interval
 	}
+	return interval - delta + time.Duration(me.int63n(int64(2*delta)))
 }
 
-func (me *SetIntervalFuncTask) Run() {
+func (me *intervalOptions) run(baseFunc GetIntervalFunc) *IntervalHandle {
+	handle := newIntervalHandle()
+	if me.randomRange[1] > 0 {
+		baseFunc = func() time.Duration { return me.randomIn(me.randomRange) }
+	}
+	intervalFunc := func() time.Duration { return me.applyJitter(baseFunc()) }
+	clock := me.clock
+	if clock == nil {
+		clock = DefaultClock()
+	}
+
+	failures := &intervalFailures{
+		intervalOptions: me,
+		handle:          handle,
+	}
 	if me.skipFirstInterval {
-		me.callback()
+		failures.call()
 	}
 
-	closedChan := make(chan time.Time, 1)
-	close(closedChan)
+	runner := &overlapRunner{
+		policy:   me.overlapPolicy,
+		limit:    me.maxConcurrent,
+		callback: failures.call,
+		skipped:  &handle.skipped,
+	}
 
 	fun := func() {
-		var interval time.Duration
-		var timer *time.Timer
+		var timer Timer
 		var c <-chan time.Time
-		end := time.Now().Add(me.intervalFunc())
+		next := clock.Now().Add(intervalFunc()) // 下一次执行的时刻
+		following := intervalFunc()             // 下一次执行之后的间隔
 
-		startCounter := func() {
-			interval = me.intervalFunc()
-			now := time.Now()
-			if now.Before(end) { // 剩余时间还可以休眠
-				end0 := end
-				end = end.Add(interval)
-				timer = time.NewTimer(time.Until(end0))
-				c = timer.C
-			} else {
-				end = now.Add(interval)
-				c = closedChan
+		stopCounter := func() {
+			if timer != nil {
+				timer.Stop()
+				timer = nil
 			}
+			c = nil
+		}
+		startCounter := func() {
+			stopCounter()
+			timer = clock.NewTimer(next.Sub(clock.Now())) // 已经过了执行时刻则马上执行
+			c = timer.C()
 		}
 		startCounter()
 
 		defer func() {
-			if timer != nil {
-				timer.Stop()
-			}
+			stopCounter()
+			runner.wg.Wait()
 			if me.cleanupFunc != nil {
 				me.cleanupFunc()
 			}
+			handle.done.Set()
 		}()
 		for {
 			select {
 			case <-c:
-				me.callback()
+				runner.fire()
+				if delay, ok := failures.backoffDelay(); ok {
+					next = clock.Now().Add(delay)
+				} else if me.overlapPolicy == OverlapDelayNext {
+					next = clock.Now().Add(following)
+				} else if next = next.Add(following); next.Before(clock.Now()) { // 回调执行时间过长，马上再执行一次
+					if me.keepPhase && following > 0 {
+						next = next.Add(clock.Now().Sub(next) / following * following) // 之后保持原来的节奏
+					} else {
+						next = clock.Now() // 之后从现在开始计时
+					}
+				}
+				following = intervalFunc()
 				startCounter()
+			case <-handle.wake:
+				paused, reset, triggers := handle.takeCommands()
+				if reset > 0 {
+					baseFunc = func() time.Duration { return reset }
+				}
+				for ; triggers > 0; triggers-- {
+					runner.fire()
+				}
+				if paused {
+					stopCounter()
+				} else if reset > 0 || c == nil { // 重新开始计时
+					next = clock.Now().Add(intervalFunc())
+					following = intervalFunc()
+					startCounter()
+				}
+			case <-handle.stop.Done():
+				return
 			case <-me.ctx.Done():
 				return
 			case <-ProgramDone():
@@ -200,35 +403,256 @@ func (me *SetIntervalFuncTask) Run() {
 	} else {
 		fun()
 	}
+	return handle
 }
 
-func (me *SetIntervalFuncTask) RunInCurrentGoProc() {
-	me.runInCurrentGoProc = true
-	me.Run()
+// 回调执行时间超过间隔时的处理策略
+type OverlapPolicy int
+
+const (
+	OverlapInline     OverlapPolicy = iota // 默认，在定时器go proc里执行回调，期间到期的执行会合并成一次，回调结束后马上执行
+	OverlapSkip                            // 在新的go proc里执行回调，上一次还没结束则跳过
+	OverlapQueueOne                        // 在新的go proc里执行回调，上一次还没结束则排队一次，结束后马上执行，多余的跳过
+	OverlapConcurrent                      // 在新的go proc里执行回调，最多同时执行WithMaxConcurrent个(默认不限制)，多余的跳过
+	OverlapDelayNext                       // 在定时器go proc里执行回调，回调结束后才开始计算下一次间隔
+)
+
+// 按OverlapPolicy执行回调
+type overlapRunner struct {
+	policy   OverlapPolicy
+	limit    int
+	callback TimerCallback
+	skipped  *atomic.Int64
+
+	mu      sync.Mutex
+	running int
+	pending bool
+	wg      sync.WaitGroup
+}
+
+func (me *overlapRunner) fire() {
+	limit := 1
+	switch me.policy {
+	case OverlapInline, OverlapDelayNext:
+		me.callback()
+		return
+	case OverlapConcurrent:
+		limit = me.limit
+		if limit <= 0 { // 没有调用WithMaxConcurrent，不限制
+			limit = math.MaxInt
+		}
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	switch {
+	case me.running < limit:
+		me.running++
+		me.wg.Add(1)
+		go me.loop()
+	case me.policy == OverlapQueueOne && !me.pending:
+		me.pending = true
+	default:
+		me.skipped.Add(1)
+	}
+}
+
+func (me *overlapRunner) loop() {
+	defer me.wg.Done()
+	for {
+		me.callback()
+
+		me.mu.Lock()
+		if !me.pending {
+			me.running--
+			me.mu.Unlock()
+			return
+		}
+		me.pending = false
+		me.mu.Unlock()
+	}
+}
+
+// 执行回调并统计连续失败次数
+type intervalFailures struct {
+	*intervalOptions
+	handle *IntervalHandle
+
+	consecutive atomic.Int32
+	prevDelay   time.Duration // 只在定时器go proc里使用
+}
+
+func (me *intervalFailures) call() {
+	err := me.invoke()
+	if err == nil {
+		me.consecutive.Store(0)
+		return
+	}
+
+	n := me.consecutive.Add(1)
+	if me.errorHandler != nil {
+		me.errorHandler(err)
+	} else {
+		reportUnhandled(err)
+	}
+	if me.maxFailures > 0 && int(n) >= me.maxFailures {
+		me.handle.stopWithError(err)
+	}
+}
+
+// 执行回调，把panic转换成PanicError
+func (me *intervalFailures) invoke() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewPanicError(r)
+		}
+	}()
+	if me.errCallback != nil {
+		return me.errCallback()
+	}
+	me.callback()
+	return nil
+}
+
+// 连续失败时，按failureBackoff计算的下一次间隔
+func (me *intervalFailures) backoffDelay() (time.Duration, bool) {
+	n := int(me.consecutive.Load())
+	if me.failureBackoff == nil || n == 0 {
+		me.prevDelay = 0
+		return 0, false
+	}
+	me.prevDelay = me.failureBackoff.Next(n, me.prevDelay)
+	return me.prevDelay, true
+}
+
+// 控制运行中的定时器。所有方法都不会阻塞，可以在回调中调用
+type IntervalHandle struct {
+	stop *Event
+	done *Event
+	wake chan struct{}
+
+	mu       sync.Mutex
+	paused   bool
+	reset    time.Duration
+	triggers int
+	err      error
+
+	skipped atomic.Int64
+}
+
+func newIntervalHandle() *IntervalHandle {
+	return &IntervalHandle{
+		stop: NewEvent(),
+		done: NewEvent(),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (me *IntervalHandle) update(f func()) {
+	me.mu.Lock()
+	f()
+	me.mu.Unlock()
+	TryWriteChan(me.wake, struct{}{})
+}
+
+func (me *IntervalHandle) takeCommands() (paused bool, reset time.Duration, triggers int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	paused, reset, triggers = me.paused, me.reset, me.triggers
+	me.reset = 0
+	me.triggers = 0
+	return
+}
+
+// 停止定时器，清理函数执行完之后Done()被关闭
+func (me *IntervalHandle) Stop() {
+	me.stop.Set()
+}
+
+func (me *IntervalHandle) stopWithError(err error) {
+	me.mu.Lock()
+	if me.err == nil {
+		me.err = err
+	}
+	me.mu.Unlock()
+	me.stop.Set()
+}
+
+// 定时器因为WithMaxFailures停止时，返回最后一次的错误
+func (me *IntervalHandle) Err() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.err
+}
+
+// 暂停计时，正在执行的回调不受影响
+func (me *IntervalHandle) Pause() {
+	me.update(func() { me.paused = true })
+}
+
+// 恢复计时，从现在开始重新计算间隔
+func (me *IntervalHandle) Resume() {
+	me.update(func() { me.paused = false })
+}
+
+// 改为固定间隔interval，从现在开始重新计时
+func (me *IntervalHandle) Reset(interval time.Duration) {
+	if interval <= 0 {
+		panic(`interval must be > 0`)
+	}
+	me.update(func() { me.reset = interval })
+}
+
+// 马上执行一次回调，不影响原来的计时
+func (me *IntervalHandle) TriggerNow() {
+	me.update(func() { me.triggers++ })
+}
+
+// 因为OverlapPolicy而跳过的执行次数
+func (me *IntervalHandle) SkippedTicks() int64 {
+	return me.skipped.Load()
+}
+
+// 定时器结束、回调和清理函数都执行完之后关闭
+func (me *IntervalHandle) Done() <-chan struct{} {
+	return me.done.Done()
 }
 
 // 定时器，如果程序收到退出信号，定时器会自动取消
 func SetInterval(interval time.Duration, callback TimerCallback) *SetIntervalTask {
 	return &SetIntervalTask{
+		intervalOptions: intervalOptions{
+			callback: callback,
+			ctx:      context.Background(),
+		},
 		interval: interval,
-		callback: callback,
-		ctx:      context.Background(),
 	}
 }
 
 func SetIntervalMS(intervalMs int64, callback TimerCallback) *SetIntervalTask {
-	return &SetIntervalTask{
-		interval: time.Duration(intervalMs) * time.Millisecond,
-		callback: callback,
-		ctx:      context.Background(),
-	}
+	return SetInterval(time.Duration(intervalMs)*time.Millisecond, callback)
+}
+
+// 回调返回error的定时器，配合OnError、WithMaxFailures和WithFailureBackoff使用
+func SetIntervalErr(interval time.Duration, callback TimerErrorCallback) *SetIntervalTask {
+	result := SetInterval(interval, nil)
+	result.errCallback = callback
+	return result
+}
+
+func SetIntervalFuncErr(intervalFunc GetIntervalFunc, callback TimerErrorCallback) *SetIntervalFuncTask {
+	result := SetIntervalFunc(intervalFunc, nil)
+	result.errCallback = callback
+	return result
 }
 
 func SetIntervalFunc(intervalFunc GetIntervalFunc, callback TimerCallback) *SetIntervalFuncTask {
 	return &SetIntervalFuncTask{
+		intervalOptions: intervalOptions{
+			callback: callback,
+			ctx:      context.Background(),
+		},
 		intervalFunc: intervalFunc,
-		callback:     callback,
-		ctx:          context.Background(),
 	}
 }
 
@@ -244,54 +668,34 @@ func SetTimeout(duration time.Duration, callback TimerCallback) {
 	}
 }
 
-// func SetIntervalRandomMS(intervalMinMs, intervalMaxMs int64, callback TimerCallback) {
-// 	Range := [2]time.Duration{time.Duration(intervalMinMs) * time.Millisecond, time.Duration(intervalMaxMs) * time.Millisecond}
-// 	SetIntervalRandom(Range, callback)
-// }
-
-// func SetIntervalRandom(_range [2]time.Duration, callback TimerCallback) {
-// 	timer := helper.NextTimer(_range)
-// 	for {
-// 		select {
-// 		case <-timer.C:
-// 			timer = helper.NextTimer(_range)
-// 			callback()
-// 		case <-ProgramDone():
-// 			timer.Stop()
-// 			return
-// 		}
-// 	}
-// }
-
-// func SetIntervalRandomFunc(intervalFunc func() [2]time.Duration, callback TimerCallback) {
-// 	timer := helper.NextTimer(intervalFunc())
-// 	for {
-// 		select {
-// 		case <-timer.C:
-// 			timer = helper.NextTimer(intervalFunc())
-// 			callback()
-// 		case <-ProgramDone():
-// 			timer.Stop()
-// 			return
-// 		}
-// 	}
-// }
+// 每次的间隔在_range[0]到_range[1]之间随机
+func SetIntervalRandom(_range [2]time.Duration, callback TimerCallback) *SetIntervalTask {
+	return SetInterval(_range[0], callback).WithRandomRange(_range[0], _range[1])
+}
+
+func SetIntervalRandomMS(intervalMinMs, intervalMaxMs int64, callback TimerCallback) *SetIntervalTask {
+	Range := [2]time.Duration{time.Duration(intervalMinMs) * time.Millisecond, time.Duration(intervalMaxMs) * time.Millisecond}
+	return SetIntervalRandom(Range, callback)
+}
+
+// 每次都调用intervalFunc获取随机范围
+func SetIntervalRandomFunc(intervalFunc func() [2]time.Duration, callback TimerCallback) *SetIntervalFuncTask {
+	task := SetIntervalFunc(nil, callback)
+	task.intervalFunc = func() time.Duration { return task.randomIn(intervalFunc()) }
+	return task
+}
 
 // 等待，如果程序收到退出信号，则马上返回err
 func SleepMS(intervalMs int64) error {
-	timer := time.NewTimer(time.Duration(intervalMs) * time.Millisecond)
-	select {
-	case <-timer.C:
-		return nil
-	case <-ProgramDone():
-		return ProgramExitingError
-	}
+	return Sleep(time.Duration(intervalMs) * time.Millisecond)
 }
 
+// 使用DefaultClock()计时
 func Sleep(duration time.Duration) error {
-	timer := time.NewTimer(duration)
+	timer := DefaultClock().NewTimer(duration)
+	defer timer.Stop()
 	select {
-	case <-timer.C:
+	case <-timer.C():
 		return nil
 	case <-ProgramDone():
 		return ProgramExitingError
@@ -309,12 +713,29 @@ type (
 		isDone      atomic.Bool
 		completion  *Event
 		cancelEvent *Event
+		cancelFunc  func() error
+
+		mu            sync.Mutex
+		finished      bool
+		continuations []func()
+
+		// 调试信息，见taskRegistry.go
+		id        uint64
+		name      string
+		status    atomic.Int32
+		startTime time.Time
+		callSite  string
 	}
 
 	// deprecated 已过时，新项目请使用 TaskT[T]，Task 后续将会移除
 	Task = TaskT[any]
 )
 
+var (
+	// 任务被取消时的错误，与任务自身返回的错误区分开
+	ErrTaskCanceled = errors.New(`task canceled`)
+)
+
 func (me *TaskT[T]) Done() <-chan struct{} {
 	return me.completion.Done()
 }
@@ -332,14 +753,15 @@ func (me *TaskT[T]) WaitWithContext(ctx context.Context) error {
 	}
 }
 
+// 超时返回context.DeadlineExceeded，使用DefaultClock()计时
 func (me *TaskT[T]) WaitTimeout(timeout time.Duration) error {
-	timeoutContext, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	timer := DefaultClock().NewTimer(timeout)
+	defer timer.Stop()
 	select {
 	case <-me.Done():
 		return nil
-	case <-timeoutContext.Done():
-		return timeoutContext.Err()
+	case <-timer.C():
+		return context.DeadlineExceeded
 	}
 }
 
@@ -347,6 +769,16 @@ func (me *TaskT[T]) IsDone() bool {
 	return me.completion.IsSet()
 }
 
+// 任务是否以ErrTaskCanceled结束
+func (me *TaskT[T]) IsCanceled() bool {
+	select {
+	case <-me.Done():
+		return errors.Is(me.err, ErrTaskCanceled)
+	default:
+		return false
+	}
+}
+
 func (me *TaskT[T]) GetResult() (T, error) {
 	<-me.Done()
 	return me.result, me.err
@@ -354,10 +786,107 @@ func (me *TaskT[T]) GetResult() (T, error) {
 
 func (me *TaskT[T]) done() {
 	me.completion.Set()
+
+	me.mu.Lock()
+	me.finished = true
+	continuations := me.continuations
+	me.continuations = nil
+	me.mu.Unlock()
+
+	for _, f := range continuations {
+		f()
+	}
+}
+
+// 设置结果并结束任务，返回false表示任务已经结束过
+func (me *TaskT[T]) complete(r T, e error) bool {
+	if me.isDone.CompareAndSwap(false, true) {
+		me.result = r
+		me.err = e
+		me.unregister()
+		me.done()
+		return true
+	}
+	return false
+}
+
+// 任务结束时在结束任务的go proc里调用f，如果任务已经结束则马上调用。等待期间不占用go proc
+func (me *TaskT[T]) onDone(f func()) {
+	me.mu.Lock()
+	if me.finished {
+		me.mu.Unlock()
+		f()
+		return
+	}
+	me.continuations = append(me.continuations, f)
+	me.mu.Unlock()
+}
+
+// 任务结束时调用f，只调用一次；如果任务已经结束则马上在当前go proc调用。
+// f在结束任务的go proc里执行，不应阻塞；f发生的panic会被recover并上报，见SetPanicHandler
+func (me *TaskT[T]) OnComplete(f func(result T, err error)) *TaskT[T] {
+	me.onDone(func() {
+		callbackNoPanic(func() { f(me.result, me.err) })
+	})
+	return me
+}
+
+// 与OnComplete相同，但f提交到scheduler执行，scheduler为nil表示启动新的go proc。
+// scheduler拒绝或者程序正在退出时，f在结束任务的go proc里执行，保证只执行一次
+func (me *TaskT[T]) OnCompleteOn(scheduler *TaskScheduler, f func(result T, err error)) *TaskT[T] {
+	me.onDone(func() {
+		callback := func() {
+			callbackNoPanic(func() { f(me.result, me.err) })
+		}
+		if err := scheduler.Submit(callback); err != nil {
+			callback()
+		}
+	})
+	return me
+}
+
+// 任务成功时调用f，见OnComplete
+func (me *TaskT[T]) OnSuccess(f func(result T)) *TaskT[T] {
+	return me.OnComplete(func(result T, err error) {
+		if err == nil {
+			f(result)
+		}
+	})
+}
+
+// 任务失败(包括被取消)时调用f，见OnComplete
+func (me *TaskT[T]) OnError(f func(err error)) *TaskT[T] {
+	return me.OnComplete(func(result T, err error) {
+		if err != nil {
+			f(err)
+		}
+	})
+}
+
+// 执行回调，把panic转换成PanicError上报
+func callbackNoPanic(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			reportUnhandled(NewPanicError(err))
+		}
+	}()
+	f()
+}
+
+// 上报没有人处理的错误。PanicError已经在创建时交给了全局panic回调，其他错误交给全局错误回调，见SetErrorHandler
+func reportUnhandled(err error) {
+	if _, ok := err.(*PanicError); ok {
+		return
+	}
+	if handler := errorHandler.Load(); handler != nil {
+		(*handler)(err)
+	}
 }
 
 func (me *TaskT[T]) Cancel() error {
-	if me.cancelEvent != nil {
+	if me.cancelFunc != nil {
+		return me.cancelFunc()
+	} else if me.cancelEvent != nil {
 		me.cancelEvent.Set()
 		return nil
 	} else {
@@ -365,22 +894,38 @@ func (me *TaskT[T]) Cancel() error {
 	}
 }
 
-func NewTaskWithResultT[T any](fun func() (T, error)) *TaskT[T] {
+func newTaskT[T any]() *TaskT[T] {
 	result := &TaskT[T]{
 		completion: NewEvent(),
 	}
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				var ok bool
-				if result.err, ok = err.(error); !ok {
-					result.err = fmt.Errorf(`%v`, err)
-				}
-			}
-			result.done()
-		}()
-		result.result, result.err = fun()
+	result.register()
+	return result
+}
+
+// 在当前go proc执行fun，并用其返回值结束任务，fun发生panic则以*PanicError结束
+func (me *TaskT[T]) run(fun func() (T, error)) {
+	var r T
+	var e error
+	defer func() {
+		if err := recover(); err != nil {
+			e = NewPanicError(err)
+		}
+		me.complete(r, e)
 	}()
+	me.status.Store(int32(TaskStatusRunning))
+	r, e = fun()
+}
+
+func NewTaskWithResultT[T any](fun func() (T, error)) *TaskT[T] {
+	return NewTaskWithResultTOn(DefaultTaskScheduler(), fun)
+}
+
+// 在指定的调度器上执行任务，scheduler为nil表示直接启动新的go proc
+func NewTaskWithResultTOn[T any](scheduler *TaskScheduler, fun func() (T, error)) *TaskT[T] {
+	result := newTaskT[T]()
+	result.submitTo(scheduler, func() {
+		result.run(fun)
+	})
 	return result
 }
 
@@ -389,38 +934,70 @@ func NewTaskWithResult(fun func() (any, error)) *Task {
 }
 
 func NewTask(fun func() error) *Task {
-	result := &Task{
-		completion: NewEvent(),
-	}
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				result.err = NewErrorf(err, `NewTask.loop`)
-			}
-			result.done()
-		}()
-		result.err = fun()
-	}()
+	return NewTaskOn(DefaultTaskScheduler(), fun)
+}
+
+// 在指定的调度器上执行任务，scheduler为nil表示直接启动新的go proc
+func NewTaskOn(scheduler *TaskScheduler, fun func() error) *Task {
+	result := newTaskT[any]()
+	result.submitTo(scheduler, func() {
+		result.run(func() (any, error) {
+			return nil, fun()
+		})
+	})
 	return result
 }
 
-func NewCancellableTask(fun func(cancelEvent *Event) error) *Task {
-	result := &Task{
-		completion:  NewEvent(),
-		cancelEvent: NewEvent(),
+// 创建可取消的任务，Cancel()会取消传给fun的ctx。
+// ctx被取消后fun返回的任何错误都记录为ErrTaskCanceled(已经匹配ErrTaskCanceled的错误保持不变)，可用IsCanceled()判断
+func NewTaskCtxT[T any](ctx context.Context, fun func(ctx context.Context) (T, error)) *TaskT[T] {
+	return NewTaskCtxTOn(DefaultTaskScheduler(), ctx, fun)
+}
+
+func NewTaskCtxTOn[T any](scheduler *TaskScheduler, ctx context.Context, fun func(ctx context.Context) (T, error)) *TaskT[T] {
+	result, start := newTaskCtxT(ctx, fun)
+	start(scheduler)
+	return result
+}
+
+// 创建可取消的任务，调用start才提交执行。用于持有锁时创建任务，释放锁之后再提交
+func newTaskCtxT[T any](ctx context.Context, fun func(ctx context.Context) (T, error)) (result *TaskT[T], start func(scheduler *TaskScheduler)) {
+	c := NewCancelCtx(ctx)
+	result = newTaskT[T]()
+	result.cancelFunc = func() error {
+		c.Cancel()
+		return nil
 	}
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				var ok bool
-				if result.err, ok = err.(error); !ok {
-					result.err = fmt.Errorf(`%v`, err)
+	start = func(scheduler *TaskScheduler) {
+		err := result.submitCtxTo(scheduler, c.Done(), func() {
+			defer c.Cancel()
+			result.run(func() (T, error) {
+				var r T
+				if c.Err() == context.Canceled { // 还没开始执行就被取消了
+					return r, ErrTaskCanceled
 				}
-			}
-			result.done()
-		}()
-		result.err = fun(result.cancelEvent)
-	}()
+				r, err := fun(c)
+				if err != nil && c.Err() == context.Canceled && !errors.Is(err, ErrTaskCanceled) {
+					err = ErrTaskCanceled
+				}
+				return r, err
+			})
+		})
+		if err != nil {
+			c.Cancel()
+		}
+	}
+	return
+}
+
+func NewCancellableTask(fun func(cancelEvent *Event) error) *Task {
+	result := newTaskT[any]()
+	result.cancelEvent = NewEvent()
+	result.submitTo(DefaultTaskScheduler(), func() {
+		result.run(func() (any, error) {
+			return nil, fun(result.cancelEvent)
+		})
+	})
 	return result
 }
 
@@ -434,28 +1011,28 @@ func NewTaskCompletionSource() *TaskCompletionSource {
 }
 
 func NewTaskCompletionSourceT[T any]() *TaskCompletionSourceT[T] {
-	return &TaskCompletionSourceT[T]{TaskT[T]{
+	result := &TaskCompletionSourceT[T]{TaskT[T]{
 		completion:  NewEvent(),
 		cancelEvent: NewEvent(),
 	}}
+	result.register()
+	return result
 }
 
 func (me *TaskCompletionSourceT[T]) SetResult(r T) bool {
-	if me.isDone.CompareAndSwap(false, true) {
-		me.result = r
-		me.completion.Set()
-		return true
-	}
-	return false
+	var e error
+	return me.complete(r, e)
 }
 
 func (me *TaskCompletionSourceT[T]) SetError(e error) bool {
-	if me.isDone.CompareAndSwap(false, true) {
-		me.err = e
-		me.completion.Set()
-		return true
-	}
-	return false
+	var r T
+	return me.complete(r, e)
+}
+
+// 以ErrTaskCanceled结束任务
+func (me *TaskCompletionSourceT[T]) SetCanceled() bool {
+	var r T
+	return me.complete(r, ErrTaskCanceled)
 }
 
 // 等待任一任务完成，如果收到程序退出信号，则马上返回且error不为nil
@@ -510,3 +1087,64 @@ func WaitAllTasks(tasks ...*Task) error {
 		}
 	}
 }
+
+func waitSelectCases[T any](ctx context.Context, tasks []*TaskT[T]) []reflect.SelectCase {
+	set := make([]reflect.SelectCase, 0, len(tasks)+2)
+	for _, t := range tasks {
+		set = append(set, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(t.Done()),
+		})
+	}
+	set = append(set, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ctx.Done()),
+	}, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ProgramDone()),
+	})
+	return set
+}
+
+// 等待任一任务完成，返回完成任务的序号、结果和错误。
+// 如果ctx结束或收到程序退出信号，则马上返回-1，error为ctx.Err()或ProgramExitingError
+func WaitAnyT[T any](ctx context.Context, tasks ...*TaskT[T]) (int, T, error) {
+	var zero T
+	if len(tasks) == 0 {
+		return -1, zero, nil
+	}
+
+	set := waitSelectCases(ctx, tasks)
+	from, _, _ := reflect.Select(set)
+	switch from {
+	case len(set) - 2:
+		return -1, zero, ctx.Err()
+	case len(set) - 1:
+		return -1, zero, ProgramExitingError
+	}
+	r, err := tasks[from].GetResult()
+	return from, r, err
+}
+
+// 等待所有任务完成，按顺序返回所有结果，error为所有任务错误的合并(errors.Join)。
+// 如果ctx结束或收到程序退出信号，则马上返回nil，error为ctx.Err()或ProgramExitingError
+func WaitAllT[T any](ctx context.Context, tasks ...*TaskT[T]) ([]T, error) {
+	set := waitSelectCases(ctx, tasks)
+	for len(set) > 2 {
+		from, _, _ := reflect.Select(set)
+		switch from {
+		case len(set) - 2:
+			return nil, ctx.Err()
+		case len(set) - 1:
+			return nil, ProgramExitingError
+		}
+		set = append(set[0:from], set[from+1:]...)
+	}
+
+	results := make([]T, len(tasks))
+	errs := make([]error, len(tasks))
+	for i, t := range tasks {
+		results[i], errs[i] = t.GetResult()
+	}
+	return results, errors.Join(errs...)
+}
@@ -2,9 +2,13 @@ package common
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -173,22 +177,39 @@ func TestConcurrent(t *testing.T) {
 	}
 }
 
+// 不断推进clock直到done，用来驱动使用FakeClock的定时器
+func driveClock(clock *FakeClock, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if clock.PendingTimers() == 0 { // 等待定时器go proc创建新的timer
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+}
+
 func TestSetIntervalFuncLong(t *testing.T) {
 	count := 0
-	start := time.Now()
+	clock := NewFakeClock(time.Now())
+	start := clock.Now()
 	context := NewCancelCtx(context.Background())
 	SetIntervalFunc(func() time.Duration { return 500 * time.Millisecond },
 		func() {
-			fmt.Printf("%v\n", time.Now())
-			time.Sleep(time.Millisecond * 1000)
+			fmt.Printf("%v\n", clock.Now())
+			clock.Sleep(time.Millisecond * 1000)
 			count++
 			if count >= 10 {
 				context.Cancel()
 			}
-		}).WithContext(context, nil).Run()
-	<-context.Done()
+		}).WithContext(context, nil).WithClock(clock).Run()
+	driveClock(clock, context.Done())
 
-	duration := time.Since(start)
+	duration := clock.Now().Sub(start)
 	if count != 10 {
 		t.Fail()
 	}
@@ -199,20 +220,21 @@ func TestSetIntervalFuncLong(t *testing.T) {
 
 func TestSetIntervalFuncShort(t *testing.T) {
 	count := 0
-	start := time.Now()
+	clock := NewFakeClock(time.Now())
+	start := clock.Now()
 	context := NewCancelCtx(context.Background())
 	SetIntervalFunc(func() time.Duration { return 1000 * time.Millisecond },
 		func() {
-			fmt.Printf("%v\n", time.Now())
-			time.Sleep(time.Millisecond * 500)
+			fmt.Printf("%v\n", clock.Now())
+			clock.Sleep(time.Millisecond * 500)
 			count++
 			if count >= 10 {
 				context.Cancel()
 			}
-		}).WithContext(context, nil).Run()
-	<-context.Done()
+		}).WithContext(context, nil).WithClock(clock).Run()
+	driveClock(clock, context.Done())
 
-	duration := time.Since(start)
+	duration := clock.Now().Sub(start)
 	if count != 10 {
 		t.Fail()
 	}
@@ -223,17 +245,19 @@ func TestSetIntervalFuncShort(t *testing.T) {
 
 func TestSetIntervalFuncVar(t *testing.T) {
 	i := 0
-	times := []time.Time{time.Now()}
+	clock := NewFakeClock(time.Now())
+	times := []time.Time{clock.Now()}
 	cancel := NewCancelCtx(context.Background())
+	go driveClock(clock, cancel.Done())
 	SetIntervalFunc(func() time.Duration {
 		i++
 		return time.Duration(i) * time.Second
 	}, func() {
-		times = append(times, time.Now())
+		times = append(times, clock.Now())
 		if i > 3 {
 			cancel.Cancel()
 		}
-	}).WithContext(cancel, nil).RunInCurrentGoProc()
+	}).WithContext(cancel, nil).WithClock(clock).RunInCurrentGoProc()
 
 	if math.Round(times[1].Sub(times[0]).Seconds()) != 1 {
 		t.Error(1, math.Round(times[1].Sub(times[0]).Seconds()))
@@ -271,3 +295,408 @@ func TestTaskCompletionSourceT(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWaitAnyT(t *testing.T) {
+	t1 := NewTaskWithResultT(func() (int, error) {
+		time.Sleep(100 * time.Millisecond)
+		return 1, nil
+	})
+	t2 := NewTaskWithResultT(func() (int, error) {
+		return 2, nil
+	})
+	i, r, err := WaitAnyT(context.Background(), t1, t2)
+	if i != 1 || r != 2 || err != nil {
+		t.Errorf(`WaitAnyT got %d %d %v, expected 1 2 nil`, i, r, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	t3 := NewTaskCompletionSourceT[int]()
+	if i, _, err = WaitAnyT(ctx, &t3.TaskT); i != -1 || err != context.DeadlineExceeded {
+		t.Errorf(`WaitAnyT got %d %v, expected -1 DeadlineExceeded`, i, err)
+	}
+}
+
+func TestWaitAllT(t *testing.T) {
+	testErr := fmt.Errorf(`test error`)
+	t1 := NewTaskWithResultT(func() (int, error) {
+		time.Sleep(100 * time.Millisecond)
+		return 1, nil
+	})
+	t2 := NewTaskWithResultT(func() (int, error) {
+		return 2, testErr
+	})
+	results, err := WaitAllT(context.Background(), t1, t2)
+	if len(results) != 2 || results[0] != 1 || results[1] != 2 {
+		t.Errorf(`WaitAllT got %v, expected [1 2]`, results)
+	}
+	if !errors.Is(err, testErr) {
+		t.Errorf(`WaitAllT err = %v, expected test error`, err)
+	}
+}
+
+func TestTaskCtxTCancel(t *testing.T) {
+	task := NewTaskCtxT(context.Background(), func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if task.IsCanceled() {
+		t.Errorf(`should not be canceled yet`)
+	}
+	if err := task.Cancel(); err != nil {
+		t.Errorf(`Cancel failed: %v`, err)
+	}
+	if _, err := task.GetResult(); err != ErrTaskCanceled || !task.IsCanceled() {
+		t.Errorf(`err = %v, expected ErrTaskCanceled`, err)
+	}
+
+	testErr := fmt.Errorf(`test error`)
+	task = NewTaskCtxT(context.Background(), func(ctx context.Context) (int, error) {
+		return 0, testErr
+	})
+	if _, err := task.GetResult(); err != testErr || task.IsCanceled() {
+		t.Errorf(`err = %v, expected test error`, err)
+	}
+
+	c := NewTaskCompletionSourceT[int]()
+	c.SetCanceled()
+	if !c.IsCanceled() {
+		t.Errorf(`TaskCompletionSourceT should be canceled`)
+	}
+}
+
+func TestTaskPanic(t *testing.T) {
+	var reported atomic.Int32
+	SetPanicHandler(func(e *PanicError) { reported.Add(1) })
+	defer SetPanicHandler(nil)
+
+	panicker := func() int {
+		panic(`test panic`)
+	}
+	tasks := []*Task{
+		NewTask(func() error { panicker(); return nil }),
+		NewTaskWithResult(func() (any, error) { return panicker(), nil }),
+		NewCancellableTask(func(*Event) error { panicker(); return nil }),
+	}
+	for i, task := range tasks {
+		_, err := task.GetResult()
+		var pe *PanicError
+		if !errors.As(err, &pe) {
+			t.Errorf(`task %d: err = %v, expected PanicError`, i, err)
+			continue
+		}
+		if pe.Value != `test panic` || !strings.Contains(pe.Stack, `panic(`) {
+			t.Errorf(`task %d: value = %v, stack = %s`, i, pe.Value, pe.Stack)
+		}
+		var base BaseError
+		if !errors.As(err, &base) || base.Stack != pe.Stack {
+			t.Errorf(`task %d: err should also match BaseError`, i)
+		}
+	}
+	if reported.Load() != int32(len(tasks)) {
+		t.Errorf(`panic handler called %d times, expected %d`, reported.Load(), len(tasks))
+	}
+}
+
+func TestTaskCallbacks(t *testing.T) {
+	var panics atomic.Int32
+	SetPanicHandler(func(e *PanicError) { panics.Add(1) })
+	defer SetPanicHandler(nil)
+
+	c := NewTaskCompletionSourceT[int]()
+	var completed, succeeded, failed atomic.Int32
+	c.OnComplete(func(r int, err error) {
+		completed.Add(1)
+		panic(`callback panic`)
+	}).OnSuccess(func(r int) {
+		succeeded.Add(1)
+	}).OnError(func(err error) {
+		failed.Add(1)
+	})
+	scheduled := NewTaskCompletionSourceT[int]()
+	c.OnCompleteOn(nil, func(r int, err error) {
+		scheduled.SetResult(r)
+	})
+
+	c.SetResult(123)
+	c.SetResult(456)
+	if completed.Load() != 1 || succeeded.Load() != 1 || failed.Load() != 0 {
+		t.Errorf(`completed = %d, succeeded = %d, failed = %d, expected 1 1 0`, completed.Load(), succeeded.Load(), failed.Load())
+	}
+	if panics.Load() != 1 {
+		t.Errorf(`callback panic not reported`)
+	}
+	if r, _ := scheduled.GetResult(); r != 123 {
+		t.Errorf(`OnCompleteOn got %d, expected 123`, r)
+	}
+
+	called := false
+	c.OnSuccess(func(r int) { called = true })
+	if !called {
+		t.Errorf(`callback on finished task should be called immediately`)
+	}
+
+	full := NewTaskScheduler(1, 0, RejectPolicyReject)
+	block := make(chan struct{})
+	defer close(block)
+	full.Submit(func() { <-block })
+	called = false
+	c.OnCompleteOn(full, func(r int, err error) { called = true })
+	if !called {
+		t.Errorf(`callback rejected by scheduler should run inline`)
+	}
+}
+
+func TestIntervalHandle(t *testing.T) {
+	var count atomic.Int32
+	cleaned := false
+	handle := SetInterval(20*time.Millisecond, func() {
+		count.Add(1)
+	}).WithContext(context.Background(), func() { cleaned = true }).Run()
+
+	time.Sleep(110 * time.Millisecond)
+	if n := count.Load(); n < 4 || n > 6 {
+		t.Errorf(`count = %d, expected about 5`, n)
+	}
+
+	handle.Pause()
+	time.Sleep(10 * time.Millisecond)
+	paused := count.Load()
+	time.Sleep(100 * time.Millisecond)
+	if count.Load() != paused {
+		t.Errorf(`paused timer should not fire`)
+	}
+
+	handle.TriggerNow()
+	time.Sleep(10 * time.Millisecond)
+	if count.Load() != paused+1 {
+		t.Errorf(`TriggerNow should fire once`)
+	}
+
+	handle.Reset(time.Hour)
+	handle.Resume()
+	time.Sleep(50 * time.Millisecond)
+	if count.Load() != paused+1 {
+		t.Errorf(`Reset interval not applied`)
+	}
+
+	handle.Reset(10 * time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	if count.Load() < paused+4 {
+		t.Errorf(`Reset interval not applied`)
+	}
+
+	handle.Stop()
+	select {
+	case <-handle.Done():
+	case <-time.After(time.Second):
+		t.Fatalf(`Done not closed after Stop`)
+	}
+	if !cleaned {
+		t.Errorf(`cleanup not called before Done`)
+	}
+}
+
+func TestIntervalOverlap(t *testing.T) {
+	run := func(task *SetIntervalTask) (calls, maxRunning int32, skipped int64) {
+		var count atomic.Int32
+		var tracker concurrencyTracker
+		task.callback = func() {
+			count.Add(1)
+			defer tracker.enter()()
+			time.Sleep(70 * time.Millisecond)
+		}
+		handle := task.Run()
+		time.Sleep(300 * time.Millisecond)
+		handle.Stop()
+		<-handle.Done()
+		return count.Load(), tracker.max.Load(), handle.SkippedTicks()
+	}
+
+	calls, maxRunning, skipped := run(SetInterval(20*time.Millisecond, nil).WithOverlap(OverlapSkip))
+	if maxRunning != 1 || skipped == 0 || calls < 3 || calls > 5 {
+		t.Errorf(`OverlapSkip: calls = %d, max running = %d, skipped = %d`, calls, maxRunning, skipped)
+	}
+
+	calls, maxRunning, skipped = run(SetInterval(20*time.Millisecond, nil).WithOverlap(OverlapQueueOne))
+	if maxRunning != 1 || skipped == 0 || calls < 4 || calls > 6 {
+		t.Errorf(`OverlapQueueOne: calls = %d, max running = %d, skipped = %d`, calls, maxRunning, skipped)
+	}
+
+	calls, maxRunning, skipped = run(SetInterval(20*time.Millisecond, nil).WithMaxConcurrent(2))
+	if maxRunning != 2 || skipped == 0 {
+		t.Errorf(`OverlapConcurrent: calls = %d, max running = %d, skipped = %d`, calls, maxRunning, skipped)
+	}
+
+	calls, maxRunning, skipped = run(SetInterval(20*time.Millisecond, nil).WithOverlap(OverlapConcurrent))
+	if maxRunning < 3 || skipped != 0 || calls < 10 {
+		t.Errorf(`OverlapConcurrent without limit: calls = %d, max running = %d, skipped = %d`, calls, maxRunning, skipped)
+	}
+
+	calls, maxRunning, skipped = run(SetInterval(20*time.Millisecond, nil).WithOverlap(OverlapDelayNext))
+	if maxRunning != 1 || skipped != 0 || calls < 3 || calls > 4 {
+		t.Errorf(`OverlapDelayNext: calls = %d, max running = %d, skipped = %d`, calls, maxRunning, skipped)
+	}
+}
+
+func TestIntervalJitter(t *testing.T) {
+	sample := func(seed int64) []time.Duration {
+		task := SetInterval(100*time.Millisecond, nil).WithJitter(0.2).WithRand(rand.New(rand.NewSource(seed)))
+		var result []time.Duration
+		for i := 0; i < 1000; i++ {
+			result = append(result, task.applyJitter(task.interval))
+		}
+		return result
+	}
+	a, b := sample(1), sample(1)
+	distinct := map[time.Duration]bool{}
+	for i, d := range a {
+		if d < 80*time.Millisecond || d >= 120*time.Millisecond {
+			t.Errorf(`jitter out of range: %v`, d)
+		}
+		if d != b[i] {
+			t.Errorf(`same seed gives different intervals: %v != %v`, d, b[i])
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 100 {
+		t.Errorf(`only %d distinct intervals`, len(distinct))
+	}
+
+	clock := NewFakeClock(time.Now())
+	times := make(chan time.Time, 1)
+	handle := SetIntervalRandom([2]time.Duration{30 * time.Millisecond, 60 * time.Millisecond}, func() {
+		times <- clock.Now()
+	}).WithRand(rand.New(rand.NewSource(2))).WithClock(clock).Run()
+	last := clock.Now()
+	gaps := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		clock.BlockUntil(1)
+		for clock.PendingTimers() > 0 {
+			clock.Advance(time.Millisecond)
+		}
+		now := <-times
+		gap := now.Sub(last)
+		if gap < 30*time.Millisecond || gap > 61*time.Millisecond {
+			t.Errorf(`gap out of range: %v`, gap)
+		}
+		gaps[gap] = true
+		last = now
+	}
+	handle.Stop()
+	<-handle.Done()
+	if len(gaps) < 5 {
+		t.Errorf(`only %d distinct gaps`, len(gaps))
+	}
+}
+
+func TestIntervalFailures(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var errs []error
+	handle := SetInterval(time.Hour, func() {
+		panic(`boom`)
+	}).OnError(func(err error) {
+		errs = append(errs, err)
+	}).WithMaxFailures(3).WithClock(clock).Run()
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour)
+	}
+	<-handle.Done()
+	var panicErr *PanicError
+	if len(errs) != 3 || !errors.As(handle.Err(), &panicErr) || panicErr.Value != `boom` {
+		t.Errorf(`errs = %v, handle.Err() = %v`, errs, handle.Err())
+	}
+	var base BaseError
+	if !errors.As(handle.Err(), &base) {
+		t.Errorf(`interval panic should match BaseError`)
+	}
+
+	times := make(chan time.Time, 1)
+	calls := 0
+	start := clock.Now()
+	handle = SetIntervalErr(time.Minute, func() error {
+		times <- clock.Now()
+		if calls++; calls <= 3 {
+			return errors.New(`failed`)
+		}
+		return nil
+	}).WithFailureBackoff(ExponentialBackoff(time.Second, 0, 2)).WithClock(clock).Run()
+	expected := []time.Duration{time.Minute, time.Second, 2 * time.Second, 4 * time.Second, time.Minute}
+	for _, d := range expected {
+		clock.BlockUntil(1)
+		for clock.PendingTimers() > 0 {
+			clock.Advance(time.Second)
+		}
+		now := <-times
+		if now.Sub(start) != d {
+			t.Errorf(`interval = %v, expected %v`, now.Sub(start), d)
+		}
+		start = now
+	}
+	handle.Stop()
+	<-handle.Done()
+	if handle.Err() != nil {
+		t.Errorf(`handle.Err() = %v`, handle.Err())
+	}
+
+	unhandled := make(chan error, 1)
+	SetErrorHandler(func(err error) { unhandled <- err })
+	defer SetErrorHandler(nil)
+	handle = SetIntervalErr(time.Minute, func() error {
+		return errors.New(`unhandled failure`)
+	}).WithMaxFailures(1).WithClock(clock).Run()
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	<-handle.Done()
+	select {
+	case err := <-unhandled:
+		if err.Error() != `unhandled failure` {
+			t.Errorf(`unhandled error = %v`, err)
+		}
+	default:
+		t.Errorf(`error without OnError should be reported to the error handler`)
+	}
+}
+
+func TestIntervalTickerPhase(t *testing.T) {
+	run := func(task func(TimerCallback) *IntervalHandle, clock *FakeClock) []time.Duration {
+		start := clock.Now()
+		times := make(chan time.Duration, 10)
+		first := true
+		handle := task(func() {
+			times <- clock.Now().Sub(start)
+			if first { // 模拟执行时间过长
+				first = false
+				clock.Advance(250 * time.Millisecond)
+			}
+		})
+		clock.BlockUntil(1)
+		clock.Advance(100 * time.Millisecond)
+		result := []time.Duration{<-times, <-times}
+		clock.BlockUntil(1)
+		for clock.PendingTimers() > 0 {
+			clock.Advance(10 * time.Millisecond)
+		}
+		result = append(result, <-times)
+		handle.Stop()
+		<-handle.Done()
+		return result
+	}
+
+	clock := NewFakeClock(time.Now())
+	times := run(func(f TimerCallback) *IntervalHandle {
+		return SetInterval(100*time.Millisecond, f).WithClock(clock).Run()
+	}, clock)
+	if times[0] != 100*time.Millisecond || times[1] != 350*time.Millisecond || times[2] != 400*time.Millisecond {
+		t.Errorf(`SetInterval should keep the ticker phase: %v`, times)
+	}
+
+	clock = NewFakeClock(time.Now())
+	times = run(func(f TimerCallback) *IntervalHandle {
+		return SetIntervalFunc(func() time.Duration { return 100 * time.Millisecond }, f).WithClock(clock).Run()
+	}, clock)
+	if times[0] != 100*time.Millisecond || times[1] != 350*time.Millisecond || times[2] != 450*time.Millisecond {
+		t.Errorf(`SetIntervalFunc should restart from the late run: %v`, times)
+	}
+}
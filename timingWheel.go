@@ -0,0 +1,239 @@
+package common
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+ *	分层时间轮，用于大量的超时。插入和取消都是O(1)，到期时间精确到tick
+ */
+
+type TimingWheel struct {
+	mu        sync.Mutex
+	tick      time.Duration
+	wheelSize int64
+	clock     Clock
+	start     time.Time
+	current   int64           // 已经处理到的tick
+	levels    [][]wheelBucket // 第L层每个bucket跨度为wheelSize^L个tick，按需增加
+	count     int
+	stop      *Event
+	started   bool
+}
+
+// tick: 时间精度，到期时间向上取整到tick
+// wheelSize: 每层的bucket数量
+func NewTimingWheel(tick time.Duration, wheelSize int) *TimingWheel {
+	if tick <= 0 || wheelSize < 2 {
+		panic(`tick must be > 0 and wheelSize must be >= 2`)
+	}
+	clock := DefaultClock()
+	return &TimingWheel{
+		tick:      tick,
+		wheelSize: int64(wheelSize),
+		clock:     clock,
+		start:     clock.Now(),
+		levels:    [][]wheelBucket{make([]wheelBucket, wheelSize)},
+		stop:      NewEvent(),
+	}
+}
+
+// 使用指定的时钟，默认DefaultClock()。必须在Start和AfterFunc之前调用
+func (me *TimingWheel) WithClock(clock Clock) *TimingWheel {
+	me.clock = clock
+	me.start = clock.Now()
+	return me
+}
+
+// 启动处理到期的go proc。Stop或者程序收到退出信号时结束，没到期的回调不再执行
+func (me *TimingWheel) Start() *TimingWheel {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if !me.started {
+		me.started = true
+		go me.loop()
+	}
+	return me
+}
+
+func (me *TimingWheel) Stop() {
+	me.stop.Set()
+}
+
+// 还没到期的回调数量
+func (me *TimingWheel) Pending() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.count
+}
+
+// d之后在新的go proc里执行f，返回的WheelTimer可以用来取消
+func (me *TimingWheel) AfterFunc(d time.Duration, f func()) *WheelTimer {
+	timer := &WheelTimer{
+		wheel: me,
+		f:     f,
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.schedule(timer, d)
+	return timer
+}
+
+func (me *TimingWheel) loop() {
+	ticker := me.clock.NewTicker(me.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			for _, timer := range me.advanceTo(me.clock.Now()) {
+				go callbackNoPanic(timer.f)
+			}
+		case <-me.stop.Done():
+			return
+		case <-ProgramDone():
+			return
+		}
+	}
+}
+
+func (me *TimingWheel) schedule(timer *WheelTimer, d time.Duration) {
+	expire := me.current + 1
+	if d > 0 {
+		// 从当前时间开始算，保证不会提前执行
+		elapsed := me.clock.Now().Sub(me.start)
+		if limit := time.Duration(math.MaxInt64) - elapsed - me.tick; d > limit {
+			d = limit
+		}
+		if ticks := int64((elapsed + d + me.tick - 1) / me.tick); ticks > expire {
+			expire = ticks
+		}
+	}
+	timer.expire = expire
+	me.add(timer)
+	me.count++
+}
+
+// 放到和current处于同一个上层bucket的最低一层
+func (me *TimingWheel) add(timer *WheelTimer) {
+	level, span := 0, int64(1)
+	for span <= math.MaxInt64/me.wheelSize {
+		upper := span * me.wheelSize
+		if timer.expire/upper == me.current/upper {
+			break
+		}
+		level++
+		span = upper
+	}
+	for len(me.levels) <= level {
+		me.levels = append(me.levels, make([]wheelBucket, me.wheelSize))
+	}
+	me.levels[level][(timer.expire/span)%me.wheelSize].add(timer)
+}
+
+// 处理到now为止到期的回调，返回需要执行的回调
+func (me *TimingWheel) advanceTo(now time.Time) (expired []*WheelTimer) {
+	target := int64(now.Sub(me.start) / me.tick)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for me.current < target {
+		me.current++
+
+		// 从上往下，把进入当前区间的bucket降到下一层
+		span := int64(1)
+		for level := 1; level < len(me.levels); level++ {
+			span *= me.wheelSize
+		}
+		for level := len(me.levels) - 1; level >= 1; level-- {
+			if me.current%span == 0 {
+				bucket := &me.levels[level][(me.current/span)%me.wheelSize]
+				for timer := bucket.takeAll(); timer != nil; {
+					next := timer.next
+					me.add(timer)
+					timer = next
+				}
+			}
+			span /= me.wheelSize
+		}
+
+		bucket := &me.levels[0][me.current%me.wheelSize]
+		for timer := bucket.takeAll(); timer != nil; timer = timer.next {
+			timer.bucket = nil
+			expired = append(expired, timer)
+			me.count--
+		}
+	}
+	return
+}
+
+// 时间轮上的一个回调
+type WheelTimer struct {
+	wheel  *TimingWheel
+	f      func()
+	expire int64
+	bucket *wheelBucket
+	prev   *WheelTimer
+	next   *WheelTimer
+}
+
+// 取消回调，返回false表示已经到期或者已经取消
+func (me *WheelTimer) Stop() bool {
+	me.wheel.mu.Lock()
+	defer me.wheel.mu.Unlock()
+	if me.bucket == nil {
+		return false
+	}
+	me.bucket.remove(me)
+	me.wheel.count--
+	return true
+}
+
+// 改为从现在开始d之后执行，返回false表示原来已经到期或者已经取消
+func (me *WheelTimer) Reset(d time.Duration) bool {
+	me.wheel.mu.Lock()
+	defer me.wheel.mu.Unlock()
+	active := me.bucket != nil
+	if active {
+		me.bucket.remove(me)
+		me.wheel.count--
+	}
+	me.wheel.schedule(me, d)
+	return active
+}
+
+// 双向链表，插入和删除都是O(1)
+type wheelBucket struct {
+	first *WheelTimer
+}
+
+func (me *wheelBucket) add(timer *WheelTimer) {
+	timer.bucket = me
+	timer.prev = nil
+	timer.next = me.first
+	if me.first != nil {
+		me.first.prev = timer
+	}
+	me.first = timer
+}
+
+func (me *wheelBucket) remove(timer *WheelTimer) {
+	if timer.prev != nil {
+		timer.prev.next = timer.next
+	} else {
+		me.first = timer.next
+	}
+	if timer.next != nil {
+		timer.next.prev = timer.prev
+	}
+	timer.bucket = nil
+	timer.prev = nil
+	timer.next = nil
+}
+
+// 取出所有回调，通过next遍历
+func (me *wheelBucket) takeAll() *WheelTimer {
+	first := me.first
+	me.first = nil
+	return first
+}
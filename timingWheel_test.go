@@ -0,0 +1,130 @@
+package common
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	wheel := NewTimingWheel(10*time.Millisecond, 8).WithClock(clock)
+
+	r := rand.New(rand.NewSource(1))
+	expected := map[*WheelTimer]int64{}
+	var canceled []*WheelTimer
+	for i := 0; i < 3000; i++ {
+		d := time.Duration(r.Int63n(int64(100 * time.Second)))
+		timer := wheel.AfterFunc(d, func() {})
+		if i%3 == 0 {
+			canceled = append(canceled, timer)
+		} else {
+			expected[timer] = int64((d + 10*time.Millisecond - 1) / (10 * time.Millisecond))
+		}
+	}
+	for _, timer := range canceled {
+		if !timer.Stop() || timer.Stop() {
+			t.Errorf(`Stop should report whether the timer was pending`)
+		}
+	}
+	if n := wheel.Pending(); n != len(expected) {
+		t.Errorf(`pending = %d, expected %d`, n, len(expected))
+	}
+
+	for tick := int64(1); tick <= 10000; tick++ {
+		clock.Advance(10 * time.Millisecond)
+		for _, timer := range wheel.advanceTo(clock.Now()) {
+			if expected[timer] != tick {
+				t.Errorf(`timer expected at tick %d fired at %d`, expected[timer], tick)
+			}
+			delete(expected, timer)
+		}
+	}
+	if len(expected) != 0 || wheel.Pending() != 0 {
+		t.Errorf(`%d timers never fired`, len(expected))
+	}
+
+	timer := wheel.AfterFunc(time.Second, func() {})
+	clock.Advance(500 * time.Millisecond)
+	wheel.advanceTo(clock.Now())
+	if !timer.Reset(time.Second) {
+		t.Errorf(`Reset should report the timer was pending`)
+	}
+	clock.Advance(time.Second - 10*time.Millisecond)
+	if fired := wheel.advanceTo(clock.Now()); len(fired) != 0 {
+		t.Errorf(`timer fired before the reset deadline`)
+	}
+	clock.Advance(10 * time.Millisecond)
+	if fired := wheel.advanceTo(clock.Now()); len(fired) != 1 || fired[0] != timer || timer.Stop() {
+		t.Errorf(`timer should fire after the reset deadline`)
+	}
+}
+
+func TestTimingWheelStart(t *testing.T) {
+	wheel := NewTimingWheel(time.Millisecond, 64).Start()
+	defer wheel.Stop()
+
+	var fired atomic.Int32
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		wheel.AfterFunc(20*time.Millisecond, func() {
+			fired.Add(1)
+			wg.Done()
+		})
+	}
+	wheel.AfterFunc(10*time.Millisecond, func() {
+		fired.Add(100)
+	}).Stop()
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf(`fired too early: %v`, elapsed)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := fired.Load(); n != 100 {
+		t.Errorf(`fired = %d`, n)
+	}
+}
+
+// 插入之后马上取消，对比time.AfterFunc
+func BenchmarkTimingWheelAfterFuncStop(b *testing.B) {
+	wheel := NewTimingWheel(time.Millisecond, 256).Start()
+	defer wheel.Stop()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wheel.AfterFunc(time.Minute, func() {}).Stop()
+	}
+}
+
+func BenchmarkTimeAfterFuncStop(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		time.AfterFunc(time.Minute, func() {}).Stop()
+	}
+}
+
+// b.N个超时全部到期
+func BenchmarkTimingWheelTimeout(b *testing.B) {
+	wheel := NewTimingWheel(time.Millisecond, 256).Start()
+	defer wheel.Stop()
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wheel.AfterFunc(10*time.Millisecond, wg.Done)
+	}
+	wg.Wait()
+}
+
+func BenchmarkSetTimeout(b *testing.B) {
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		go SetTimeout(10*time.Millisecond, wg.Done)
+	}
+	wg.Wait()
+}